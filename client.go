@@ -0,0 +1,400 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// errMalformedURL is returned by Dial when urlStr is not a valid ws:// or
+// wss:// URL.
+var errMalformedURL = errors.New("websocket: malformed ws or wss URL")
+
+// Dialer contains options for connecting to a WebSocket server.
+//
+// The zero value is a usable Dialer with sane defaults: it dials over plain
+// TCP or TLS depending on the URL scheme, with no proxy and no subprotocols.
+type Dialer struct {
+	// NetDialContext specifies the dial function for creating TCP
+	// connections. If NetDialContext is nil, net.Dialer's DialContext method
+	// is used.
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// TLSClientConfig specifies the TLS configuration to use for wss://
+	// connections. If nil, the default configuration is used.
+	TLSClientConfig *tls.Config
+
+	// Proxy specifies a function to return the proxy for a given request. If
+	// Proxy is nil or returns a nil *url.URL, no proxy is used. For wss://
+	// URLs the Dialer establishes the proxy tunnel with an HTTP CONNECT
+	// request before starting the TLS and WebSocket handshakes.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// HandshakeTimeout specifies the duration for the handshake to complete.
+	HandshakeTimeout time.Duration
+
+	// Subprotocols specifies the client's requested subprotocols in order of
+	// preference.
+	Subprotocols []string
+
+	// EnableCompression specifies whether the client should offer the
+	// permessage-deflate extension (RFC 7692).
+	EnableCompression bool
+
+	// CompressionLevel is used for outgoing compressed messages. It defaults
+	// to flate.DefaultCompression.
+	CompressionLevel int
+
+	// ServerNoContextTakeover requests that the server reset its compression
+	// state between messages.
+	ServerNoContextTakeover bool
+
+	// ClientNoContextTakeover requests that the client reset its compression
+	// state between messages.
+	ClientNoContextTakeover bool
+
+	// ServerMaxWindowBits bounds the LZ77 window the server uses.
+	ServerMaxWindowBits int
+
+	// ClientMaxWindowBits bounds the LZ77 window the client uses.
+	ClientMaxWindowBits int
+
+	// ReadLimit specifies the maximum size in bytes for a message read from
+	// the peer. See UpgradeOptions.ReadLimit.
+	ReadLimit int64
+
+	// PingPeriod mirrors UpgradeOptions.PingPeriod. Dial does not read it,
+	// and the returned Conn sends no automatic pings.
+	PingPeriod time.Duration
+
+	// Jar specifies the cookie jar used to manage cookies across the
+	// handshake request, mirroring http.Client.Jar.
+	Jar http.CookieJar
+
+	// EnableHTTP2 makes Dial attempt to bootstrap the connection over
+	// HTTP/2 using an RFC 8441 extended CONNECT request before falling back
+	// to the classic HTTP/1.1 Upgrade dance. This only applies to wss://
+	// URLs; the fallback is automatic if the peer does not advertise
+	// SETTINGS_ENABLE_CONNECT_PROTOCOL. The HTTP/2 attempt connects through
+	// its own http2.Transport and does not use NetDialContext, Proxy or Jar.
+	EnableHTTP2 bool
+}
+
+// DefaultDialer is a Dialer with all fields set to their default values.
+var DefaultDialer = &Dialer{
+	HandshakeTimeout: 45 * time.Second,
+}
+
+// Dial creates a new client connection by calling DefaultDialer.Dial with
+// context.Background.
+func Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	return DefaultDialer.Dial(context.Background(), urlStr, requestHeader)
+}
+
+// Dial opens a new client connection to urlStr using the options in d and
+// returns the resulting connection. It mirrors the gorilla/websocket API but
+// is context-first.
+//
+// requestHeader specifies additional headers to include in the handshake
+// request, for example Cookie or Authorization.
+//
+// The returned *http.Response is non-nil on both success and handshake
+// failure so callers can inspect the status code and body of a rejected
+// handshake. On success over HTTP/1.1 its Body is already closed; on success
+// over HTTP/2 (see EnableHTTP2) its Body is the connection's stream.
+func (d *Dialer) Dial(ctx context.Context, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var tcpScheme string
+	switch u.Scheme {
+	case "ws":
+		tcpScheme = "http"
+	case "wss":
+		tcpScheme = "https"
+	default:
+		return nil, nil, errMalformedURL
+	}
+
+	if d.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.HandshakeTimeout)
+		defer cancel()
+	}
+
+	if d.EnableHTTP2 && tcpScheme == "https" {
+		h2Header := make(http.Header)
+		for k, v := range requestHeader {
+			h2Header[k] = v
+		}
+		if len(d.Subprotocols) > 0 {
+			h2Header.Set("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+		}
+		var h2Compress *compressionParams
+		if d.EnableCompression {
+			h2Header.Set("Sec-WebSocket-Extensions", d.compressionOffer())
+			h2Compress = &compressionParams{
+				level:                   d.CompressionLevel,
+				serverNoContextTakeover: d.ServerNoContextTakeover,
+				clientNoContextTakeover: d.ClientNoContextTakeover,
+				serverMaxWindowBits:     d.ServerMaxWindowBits,
+				clientMaxWindowBits:     d.ClientMaxWindowBits,
+			}
+		}
+		if c, resp, err := dialH2(ctx, d.http2Transport(), u, h2Header, h2Compress, d.ReadLimit); err == nil {
+			return c, resp, nil
+		}
+		// Peer doesn't speak extended CONNECT (or isn't HTTP/2 at all) -
+		// fall through to the HTTP/1.1 Upgrade dance below.
+	}
+
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    u,
+		Header: make(http.Header),
+		Host:   u.Host,
+	}
+	if requestHeader != nil {
+		for k, v := range requestHeader {
+			req.Header[k] = v
+		}
+	}
+
+	if d.Jar != nil {
+		httpReq := req.Clone(ctx)
+		httpReq.URL = &url.URL{Scheme: tcpScheme, Host: u.Host, Path: u.Path}
+		for _, c := range d.Jar.Cookies(httpReq.URL) {
+			req.AddCookie(c)
+		}
+	}
+
+	key, err := generateChallengeKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(d.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+
+	var compress *compressionParams
+	if d.EnableCompression {
+		req.Header.Set("Sec-WebSocket-Extensions", d.compressionOffer())
+		compress = &compressionParams{
+			level:                   d.CompressionLevel,
+			serverNoContextTakeover: d.ServerNoContextTakeover,
+			clientNoContextTakeover: d.ClientNoContextTakeover,
+			serverMaxWindowBits:     d.ServerMaxWindowBits,
+			clientMaxWindowBits:     d.ClientMaxWindowBits,
+		}
+	}
+
+	netConn, err := d.dialNetConn(ctx, tcpScheme, u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	brw := bufio.NewReadWriter(bufio.NewReader(netConn), bufio.NewWriter(netConn))
+	if err := req.Write(brw); err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+	if err := brw.Flush(); err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+
+	resp, err := http.ReadResponse(brw.Reader, req)
+	if err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		netConn.Close()
+		return nil, resp, fmt.Errorf("websocket: bad handshake: status %s", resp.Status)
+	}
+	if !headerContainsToken(resp.Header, "Connection", "upgrade") {
+		netConn.Close()
+		return nil, resp, fmt.Errorf("websocket: bad handshake: 'Connection' header missing 'Upgrade' token")
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+		netConn.Close()
+		return nil, resp, fmt.Errorf("websocket: bad handshake: 'Upgrade' header missing 'websocket' token")
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != computeAcceptKey(key) {
+		netConn.Close()
+		return nil, resp, fmt.Errorf("websocket: bad handshake: 'Sec-WebSocket-Accept' mismatch")
+	}
+	resp.Body.Close()
+
+	if compress != nil {
+		if offer, ok := parseExtensionOffers(resp.Header, "permessage-deflate"); ok {
+			compress = negotiateCompression(offer, &UpgradeOptions{
+				CompressionLevel:        compress.level,
+				ServerNoContextTakeover: compress.serverNoContextTakeover,
+				ClientNoContextTakeover: compress.clientNoContextTakeover,
+				ServerMaxWindowBits:     compress.serverMaxWindowBits,
+				ClientMaxWindowBits:     compress.clientMaxWindowBits,
+			})
+		} else {
+			compress = nil
+		}
+	}
+
+	c := newConn(netConn, false, d.ReadLimit, compress)
+	c.br = brw.Reader
+	c.subprotocol = resp.Header.Get("Sec-WebSocket-Protocol")
+
+	if d.Jar != nil {
+		if rc := resp.Cookies(); len(rc) > 0 {
+			d.Jar.SetCookies(&url.URL{Scheme: tcpScheme, Host: u.Host, Path: u.Path}, rc)
+		}
+	}
+
+	return c, resp, nil
+}
+
+// http2Transport returns an http2.Transport configured with the Dialer's TLS
+// settings, used for the RFC 8441 extended CONNECT attempt.
+func (d *Dialer) http2Transport() *http2.Transport {
+	tlsConfig := d.TLSClientConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	return &http2.Transport{TLSClientConfig: tlsConfig}
+}
+
+func (d *Dialer) compressionOffer() string {
+	p := &compressionParams{
+		serverNoContextTakeover: d.ServerNoContextTakeover,
+		clientNoContextTakeover: d.ClientNoContextTakeover,
+		serverMaxWindowBits:     d.ServerMaxWindowBits,
+		clientMaxWindowBits:     d.ClientMaxWindowBits,
+	}
+	return p.responseHeader()
+}
+
+// dialNetConn establishes the underlying net.Conn for the handshake,
+// following any configured proxy and performing the TLS handshake for wss://
+// URLs.
+func (d *Dialer) dialNetConn(ctx context.Context, tcpScheme string, u *url.URL) (net.Conn, error) {
+	dial := d.NetDialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	hostport := u.Host
+	if !strings.Contains(hostport, ":") {
+		if tcpScheme == "https" {
+			hostport += ":443"
+		} else {
+			hostport += ":80"
+		}
+	}
+
+	var proxyURL *url.URL
+	if d.Proxy != nil {
+		probe := &http.Request{URL: &url.URL{Scheme: tcpScheme, Host: u.Host}}
+		pu, err := d.Proxy(probe)
+		if err != nil {
+			return nil, err
+		}
+		proxyURL = pu
+	}
+
+	var netConn net.Conn
+	var err error
+	if proxyURL != nil {
+		netConn, err = dial(ctx, "tcp", proxyURL.Host)
+		if err != nil {
+			return nil, err
+		}
+		if err := connectThroughProxy(ctx, netConn, proxyURL, hostport); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	} else {
+		netConn, err = dial(ctx, "tcp", hostport)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if tcpScheme == "https" {
+		tlsConfig := d.TLSClientConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(netConn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+		netConn = tlsConn
+	}
+
+	return netConn, nil
+}
+
+// connectThroughProxy issues an HTTP CONNECT request over netConn to
+// establish a tunnel to target, as used for wss:// connections through an
+// HTTP proxy.
+func connectThroughProxy(ctx context.Context, netConn net.Conn, proxyURL *url.URL, target string) error {
+	connectReq := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		connectReq.Header.Set("Proxy-Authorization", "Basic "+basicAuth(proxyURL.User))
+	}
+
+	if err := connectReq.Write(netConn); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(br, connectReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("websocket: proxy CONNECT failed: %s", resp.Status)
+	}
+	return nil
+}
+
+func basicAuth(u *url.Userinfo) string {
+	password, _ := u.Password()
+	return base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+}
+
+// generateChallengeKey returns a new Sec-WebSocket-Key value, a base64
+// encoding of 16 random bytes, per RFC 6455 section 4.1.
+func generateChallengeKey() (string, error) {
+	var b [16]byte
+	randRead(b[:])
+	return base64.StdEncoding.EncodeToString(b[:]), nil
+}
@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// closeTrackingConn wraps a net.Conn to record whether Close was called, so
+// tests can assert Dial doesn't leak the dialed connection on failure.
+type closeTrackingConn struct {
+	net.Conn
+	closed *int32
+}
+
+func (c *closeTrackingConn) Close() error {
+	atomic.StoreInt32(c.closed, 1)
+	return c.Conn.Close()
+}
+
+// TestDialClosesConnOnRejectedHandshake guards against a connection leak: a
+// server response with a status other than 101 Switching Protocols must
+// still result in the dialed net.Conn being closed.
+func TestDialClosesConnOnRejectedHandshake(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	var closed int32
+	d := &Dialer{
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &closeTrackingConn{Conn: c, closed: &closed}, nil
+		},
+	}
+
+	_, resp, err := d.Dial(context.Background(), "ws://"+srv.Listener.Addr().String(), nil)
+	if err == nil {
+		t.Fatal("Dial: expected error for a rejected handshake")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Dial: resp = %+v, want status %d", resp, http.StatusForbidden)
+	}
+	if atomic.LoadInt32(&closed) == 0 {
+		t.Fatal("Dial: dialed connection was not closed after a rejected handshake")
+	}
+}
@@ -0,0 +1,289 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// deflateTail is the empty stored block that compress/flate writes at the
+// end of a Flush or Close. RFC 7692 section 7.2.1 requires removing it from
+// outgoing frames and restoring it before inflating.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// defaultWindowSize is the LZ77 window used when no max_window_bits
+// parameter was negotiated for a side, per RFC 7692 section 7.1.2.1.
+const defaultWindowSize = 32768
+
+// compressionParams holds the permessage-deflate parameters negotiated for a
+// connection, per RFC 7692.
+type compressionParams struct {
+	level                   int
+	serverNoContextTakeover bool
+	clientNoContextTakeover bool
+	serverMaxWindowBits     int
+	clientMaxWindowBits     int
+}
+
+// parseExtensionOffers splits the Sec-WebSocket-Extensions header into the
+// offers for the named extension. ok is false if the extension was not
+// offered.
+func parseExtensionOffers(h http.Header, name string) (map[string]string, bool) {
+	for _, line := range h["Sec-Websocket-Extensions"] {
+		for _, offer := range strings.Split(line, ",") {
+			params := strings.Split(offer, ";")
+			if strings.TrimSpace(params[0]) != name {
+				continue
+			}
+			values := map[string]string{}
+			for _, p := range params[1:] {
+				kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+				k := kv[0]
+				v := ""
+				if len(kv) == 2 {
+					v = strings.Trim(kv[1], `"`)
+				}
+				values[k] = v
+			}
+			return values, true
+		}
+	}
+	return nil, false
+}
+
+// negotiateCompression computes the parameters the server will use given the
+// client's offer and the application's UpgradeOptions.
+func negotiateCompression(offer map[string]string, options *UpgradeOptions) *compressionParams {
+	p := &compressionParams{
+		level:                   options.CompressionLevel,
+		serverNoContextTakeover: options.ServerNoContextTakeover,
+		clientNoContextTakeover: options.ClientNoContextTakeover,
+		serverMaxWindowBits:     options.ServerMaxWindowBits,
+	}
+	if p.level == 0 {
+		p.level = flate.DefaultCompression
+	}
+	if _, ok := offer["server_no_context_takeover"]; ok {
+		p.serverNoContextTakeover = true
+	}
+	if _, ok := offer["client_no_context_takeover"]; ok {
+		p.clientNoContextTakeover = true
+	}
+	if v, ok := offer["server_max_window_bits"]; ok {
+		if bits, err := strconv.Atoi(v); err == nil && (p.serverMaxWindowBits == 0 || bits < p.serverMaxWindowBits) {
+			p.serverMaxWindowBits = bits
+		}
+	}
+	// RFC 7692 section 7.1.2.2: the response MUST NOT carry
+	// client_max_window_bits unless the client's offer included the
+	// parameter, so options.ClientMaxWindowBits only takes effect when the
+	// offer has the key at all - even a bare one with no value.
+	if v, ok := offer["client_max_window_bits"]; ok {
+		p.clientMaxWindowBits = options.ClientMaxWindowBits
+		if bits, err := strconv.Atoi(v); err == nil && (p.clientMaxWindowBits == 0 || bits < p.clientMaxWindowBits) {
+			p.clientMaxWindowBits = bits
+		}
+	}
+	return p
+}
+
+// responseHeader renders the negotiated parameters as a Sec-WebSocket-Extensions
+// value to echo back to the client.
+func (p *compressionParams) responseHeader() string {
+	var b strings.Builder
+	b.WriteString("permessage-deflate")
+	if p.serverNoContextTakeover {
+		b.WriteString("; server_no_context_takeover")
+	}
+	if p.clientNoContextTakeover {
+		b.WriteString("; client_no_context_takeover")
+	}
+	if p.serverMaxWindowBits != 0 {
+		b.WriteString("; server_max_window_bits=" + strconv.Itoa(p.serverMaxWindowBits))
+	}
+	if p.clientMaxWindowBits != 0 {
+		b.WriteString("; client_max_window_bits=" + strconv.Itoa(p.clientMaxWindowBits))
+	}
+	return b.String()
+}
+
+// windowBitsToSize converts a negotiated max_window_bits value (8-15, or 0
+// meaning "not specified") to the corresponding LZ77 window size in bytes.
+func windowBitsToSize(bits int) int {
+	if bits == 0 {
+		return defaultWindowSize
+	}
+	return 1 << uint(bits)
+}
+
+// writeNoContextTakeover reports whether isServer's own outgoing messages
+// must reset their compression state after every message.
+func (p *compressionParams) writeNoContextTakeover(isServer bool) bool {
+	if isServer {
+		return p.serverNoContextTakeover
+	}
+	return p.clientNoContextTakeover
+}
+
+// writeWindowSize returns the LZ77 window isServer is allowed to use when
+// compressing messages it sends.
+func (p *compressionParams) writeWindowSize(isServer bool) int {
+	if isServer {
+		return windowBitsToSize(p.serverMaxWindowBits)
+	}
+	return windowBitsToSize(p.clientMaxWindowBits)
+}
+
+// readNoContextTakeover reports whether the peer of isServer resets its
+// compression state after every message, which means isServer's decompressor
+// must also discard history between messages to stay in sync.
+func (p *compressionParams) readNoContextTakeover(isServer bool) bool {
+	if isServer {
+		return p.clientNoContextTakeover
+	}
+	return p.serverNoContextTakeover
+}
+
+// readWindowSize returns the LZ77 window the peer of isServer uses when
+// compressing the messages isServer receives.
+func (p *compressionParams) readWindowSize(isServer bool) int {
+	if isServer {
+		return windowBitsToSize(p.clientMaxWindowBits)
+	}
+	return windowBitsToSize(p.serverMaxWindowBits)
+}
+
+// appendWindow returns dict with add appended, trimmed to at most max
+// trailing bytes. The result never aliases dict's or add's backing array.
+func appendWindow(dict, add []byte, max int) []byte {
+	buf := make([]byte, 0, len(dict)+len(add))
+	buf = append(buf, dict...)
+	buf = append(buf, add...)
+	if len(buf) > max {
+		buf = buf[len(buf)-max:]
+	}
+	return buf
+}
+
+// flateWriterPool and flateBufferPool amortize the allocation cost of
+// compress/flate state across messages that start with an empty dictionary
+// (the first message on a connection, or any message after a
+// no_context_takeover reset). Once a side has built up dictionary bytes,
+// compress/flate offers no way to re-seed an existing *flate.Writer with a
+// preset dictionary, so newFlateWriter falls back to flate.NewWriterDict for
+// those messages instead of pulling from the pool.
+var flateWriterPool sync.Pool
+var flateBufferPool = sync.Pool{New: func() interface{} { return &flateBuffer{} }}
+
+// flateBuffer is a bytes.Buffer that also implements io.Writer for
+// flate.NewWriter's dst, reused across messages via flateBufferPool.
+type flateBuffer struct {
+	bytes.Buffer
+}
+
+func getFlateBuffer() *flateBuffer {
+	b := flateBufferPool.Get().(*flateBuffer)
+	b.Reset()
+	return b
+}
+
+func putFlateBuffer(b *flateBuffer) {
+	b.Reset()
+	flateBufferPool.Put(b)
+}
+
+// Flush writes any data buffered in the flate.Writer's internal buffer to
+// the frame without finishing the DEFLATE stream, so control frames and the
+// next fragment can be interleaved correctly.
+func (b *flateBuffer) Flush(w io.WriteCloser) {
+	if f, ok := w.(*flate.Writer); ok {
+		f.Flush()
+	}
+}
+
+// newFlateWriter returns a compressor for dst. When dict is non-empty, the
+// returned writer is seeded with it as a preset dictionary so the message is
+// compressed as if the connection's compression context had been carried
+// over - the standard way to emulate permessage-deflate context takeover on
+// top of compress/flate, which cannot Reset an existing *flate.Writer with a
+// new dictionary. When dict is empty, a pooled *flate.Writer is reused.
+func newFlateWriter(dst io.Writer, level int, dict []byte) io.WriteCloser {
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	if len(dict) > 0 {
+		fw, _ := flate.NewWriterDict(dst, level, dict)
+		return fw
+	}
+	if fw, ok := flateWriterPool.Get().(*flate.Writer); ok {
+		fw.Reset(dst)
+		return fw
+	}
+	fw, _ := flate.NewWriter(dst, level)
+	return fw
+}
+
+// putFlateWriter returns a closed compressor to the pool for reuse by a
+// future no-dictionary message.
+func putFlateWriter(w io.WriteCloser) {
+	if fw, ok := w.(*flate.Writer); ok {
+		flateWriterPool.Put(fw)
+	}
+}
+
+// trimDeflateTail strips the 0x00 0x00 0xff 0xff sync-flush tail that RFC
+// 7692 requires removing from the final frame of a compressed message.
+func trimDeflateTail(b *flateBuffer) {
+	data := b.Bytes()
+	if bytes.HasSuffix(data, deflateTail) {
+		b.Truncate(len(data) - len(deflateTail))
+	}
+}
+
+// limitedInflateReader restores the 0x00 0x00 0xff 0xff tail that RFC 7692
+// requires stripping from the wire, so flate.Reader sees a complete stream
+// for every message even though the wire omitted the final empty block. It
+// reads frames directly via the connection and the message's shared
+// frameState rather than holding a *Reader, since Reader values returned
+// from newMessageReader are copied into the caller's variable and a pointer
+// captured before that copy would go stale.
+type limitedInflateReader struct {
+	c      *Conn
+	state  *frameState
+	limit  int64
+	tail   io.Reader
+	usedUp bool
+}
+
+func (r *limitedInflateReader) Read(p []byte) (int, error) {
+	n, err := readContinuation(r.c, r.state, r.limit, p)
+	if err == io.EOF && !r.usedUp {
+		r.usedUp = true
+		if r.tail == nil {
+			r.tail = bytes.NewReader(deflateTail)
+		}
+		tn, terr := r.tail.Read(p[n:])
+		if tn > 0 {
+			return n + tn, nil
+		}
+		if terr == io.EOF {
+			return n, io.EOF
+		}
+	}
+	return n, err
+}
+
+// newFlateReader returns a decompressor for the message described by state.
+// When dict is non-empty it is used as a preset dictionary, mirroring
+// newFlateWriter's approach to context takeover on the sending side.
+func newFlateReader(c *Conn, state *frameState, limit int64, dict []byte) io.ReadCloser {
+	src := &limitedInflateReader{c: c, state: state, limit: limit}
+	if len(dict) > 0 {
+		return flate.NewReaderDict(src, dict)
+	}
+	return flate.NewReader(src)
+}
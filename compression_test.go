@@ -0,0 +1,217 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// TestCompressionRoundTrip guards the permessage-deflate data path end to
+// end: several messages in a row, over real Conns talking net.Pipe, must
+// decompress back to exactly what was written. Sending more than one
+// message exercises context takeover, since by default neither side resets
+// its LZ77 dictionary between messages.
+func TestCompressionRoundTrip(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	compress := &compressionParams{level: flate.DefaultCompression}
+
+	client := newConn(clientConn, false, 0, compress)
+	client.br = bufio.NewReader(clientConn)
+	server := newConn(serverConn, true, 0, compress)
+	server.br = bufio.NewReader(serverConn)
+
+	messages := []string{
+		"hello world",
+		"a second message that should compress well against the first one's dictionary",
+		"third",
+	}
+
+	writeErr := make(chan error, 1)
+	go func() {
+		for _, msg := range messages {
+			w, err := client.Writer(context.Background())
+			if err != nil {
+				writeErr <- err
+				return
+			}
+			if _, err := w.WriteString(msg); err != nil {
+				writeErr <- err
+				return
+			}
+			if err := w.Close(); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+		writeErr <- nil
+	}()
+
+	for _, want := range messages {
+		r, err := server.Reader(context.Background())
+		if err != nil {
+			t.Fatalf("Reader: %v", err)
+		}
+		if !r.compress {
+			t.Fatal("Reader: message was not marked as compressed")
+		}
+		got, err := io.ReadAll(&r)
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		if string(got) != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	if err := <-writeErr; err != nil {
+		t.Fatalf("writer goroutine: %v", err)
+	}
+}
+
+// TestCompressionNoContextTakeover guards the no_context_takeover paths on
+// both the write and read side: each message must decompress correctly even
+// though the dictionary is discarded between messages instead of carried
+// over, which is the opposite code path from TestCompressionRoundTrip.
+func TestCompressionNoContextTakeover(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	compress := &compressionParams{
+		level:                   flate.DefaultCompression,
+		clientNoContextTakeover: true,
+	}
+
+	client := newConn(clientConn, false, 0, compress)
+	client.br = bufio.NewReader(clientConn)
+	server := newConn(serverConn, true, 0, compress)
+	server.br = bufio.NewReader(serverConn)
+
+	messages := []string{"first message", "second message, unrelated dictionary"}
+
+	writeErr := make(chan error, 1)
+	go func() {
+		for _, msg := range messages {
+			w, err := client.Writer(context.Background())
+			if err != nil {
+				writeErr <- err
+				return
+			}
+			if _, err := w.WriteString(msg); err != nil {
+				writeErr <- err
+				return
+			}
+			if err := w.Close(); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+		writeErr <- nil
+	}()
+
+	for _, want := range messages {
+		r, err := server.Reader(context.Background())
+		if err != nil {
+			t.Fatalf("Reader: %v", err)
+		}
+		got, err := io.ReadAll(&r)
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		if string(got) != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	if err := <-writeErr; err != nil {
+		t.Fatalf("writer goroutine: %v", err)
+	}
+	if len(client.writeDict) != 0 {
+		t.Fatalf("client.writeDict = %d bytes, want 0 with client_no_context_takeover", len(client.writeDict))
+	}
+	if len(server.readDict) != 0 {
+		t.Fatalf("server.readDict = %d bytes, want 0 with client_no_context_takeover", len(server.readDict))
+	}
+}
+
+// TestCompressionDecompressedReadLimitEnforced guards the zip-bomb defense:
+// Reader.Read must account the limit against decompressed bytes, not wire
+// bytes, so a small compressed frame that inflates past the configured
+// ReadLimit is rejected instead of being handed to the application in full.
+func TestCompressionDecompressedReadLimitEnforced(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	// Drain anything the server writes back (the close frame CloseWrite
+	// sends once the limit is breached) so that write doesn't block forever
+	// with nothing reading the other end of the pipe.
+	go io.Copy(io.Discard, clientConn)
+
+	compress := &compressionParams{level: flate.DefaultCompression}
+
+	client := newConn(clientConn, false, 0, compress)
+	client.br = bufio.NewReader(clientConn)
+	server := newConn(serverConn, true, 4096, compress)
+	server.br = bufio.NewReader(serverConn)
+
+	// Highly repetitive, so the wire frame (~1KB) stays well under the
+	// 4096-byte limit while the decompressed message (1MB) blows past it -
+	// the shape of an actual zip-bomb payload.
+	large := bytes.Repeat([]byte("a"), 1<<20)
+
+	go func() {
+		w, err := client.Writer(context.Background())
+		if err != nil {
+			return
+		}
+		if _, err := w.Write(large); err != nil {
+			return
+		}
+		w.Close()
+	}()
+
+	r, err := server.Reader(context.Background())
+	if err != nil {
+		t.Fatalf("Reader: %v", err)
+	}
+	if _, err := io.ReadAll(&r); err == nil {
+		t.Fatal("ReadAll: expected a read-limit error for an oversized decompressed message")
+	} else if !strings.Contains(err.Error(), "read limit") {
+		t.Fatalf("ReadAll err = %v, want a read-limit error", err)
+	}
+}
+
+// TestNegotiateCompressionClientMaxWindowBitsOnlyEchoedWhenOffered guards
+// RFC 7692 section 7.1.2.2: a server response must not carry
+// client_max_window_bits unless the client's offer included the parameter,
+// even if UpgradeOptions.ClientMaxWindowBits is set.
+func TestNegotiateCompressionClientMaxWindowBitsOnlyEchoedWhenOffered(t *testing.T) {
+	options := &UpgradeOptions{ClientMaxWindowBits: 10}
+
+	p := negotiateCompression(map[string]string{}, options)
+	if p.clientMaxWindowBits != 0 {
+		t.Fatalf("clientMaxWindowBits = %d, want 0 when the offer omitted the parameter", p.clientMaxWindowBits)
+	}
+	if strings.Contains(p.responseHeader(), "client_max_window_bits") {
+		t.Fatalf("responseHeader() = %q, must not mention client_max_window_bits when the client never offered it", p.responseHeader())
+	}
+
+	p = negotiateCompression(map[string]string{"client_max_window_bits": ""}, options)
+	if p.clientMaxWindowBits != 10 {
+		t.Fatalf("clientMaxWindowBits = %d, want 10 for a bare offer that defers to our option", p.clientMaxWindowBits)
+	}
+
+	p = negotiateCompression(map[string]string{"client_max_window_bits": "8"}, options)
+	if p.clientMaxWindowBits != 8 {
+		t.Fatalf("clientMaxWindowBits = %d, want 8, the smaller of the offer and our option", p.clientMaxWindowBits)
+	}
+}
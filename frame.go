@@ -0,0 +1,155 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// opcode identifies the type of a WebSocket frame as defined in RFC 6455,
+// section 5.2.
+type opcode int
+
+const (
+	opContinuation opcode = 0x0
+	opText         opcode = 0x1
+	opBinary       opcode = 0x2
+	opClose        opcode = 0x8
+	opPing         opcode = 0x9
+	opPong         opcode = 0xa
+)
+
+// Frame header bits, RFC 6455 section 5.2.
+const (
+	finBit  = 1 << 7
+	rsv1Bit = 1 << 6
+	rsv2Bit = 1 << 5
+	rsv3Bit = 1 << 4
+	maskBit = 1 << 7
+)
+
+const maxControlFramePayload = 125
+
+var errInvalidFrame = errors.New("websocket: invalid frame")
+
+// frameHeader is the decoded form of a frame's two-to-fourteen byte header.
+type frameHeader struct {
+	fin     bool
+	rsv1    bool
+	rsv2    bool
+	rsv3    bool
+	opcode  opcode
+	masked  bool
+	maskKey [4]byte
+	length  int64
+}
+
+// readFrameHeader reads and validates a frame header from r.
+func readFrameHeader(r io.Reader) (frameHeader, error) {
+	var buf [14]byte
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return frameHeader{}, err
+	}
+
+	h := frameHeader{
+		fin:    buf[0]&finBit != 0,
+		rsv1:   buf[0]&rsv1Bit != 0,
+		rsv2:   buf[0]&rsv2Bit != 0,
+		rsv3:   buf[0]&rsv3Bit != 0,
+		opcode: opcode(buf[0] & 0xf),
+		masked: buf[1]&maskBit != 0,
+		length: int64(buf[1] &^ maskBit),
+	}
+
+	switch h.length {
+	case 126:
+		if _, err := io.ReadFull(r, buf[:2]); err != nil {
+			return frameHeader{}, err
+		}
+		h.length = int64(binary.BigEndian.Uint16(buf[:2]))
+	case 127:
+		if _, err := io.ReadFull(r, buf[:8]); err != nil {
+			return frameHeader{}, err
+		}
+		h.length = int64(binary.BigEndian.Uint64(buf[:8]))
+		if h.length < 0 {
+			return frameHeader{}, errInvalidFrame
+		}
+	}
+
+	if isControlOpcode(h.opcode) && (!h.fin || h.length > maxControlFramePayload) {
+		return frameHeader{}, errInvalidFrame
+	}
+
+	if h.masked {
+		if _, err := io.ReadFull(r, h.maskKey[:]); err != nil {
+			return frameHeader{}, err
+		}
+	}
+
+	return h, nil
+}
+
+// writeFrameHeader writes the header for a frame with the given parameters.
+func writeFrameHeader(w io.Writer, h frameHeader) error {
+	var buf [14]byte
+	n := 2
+
+	buf[0] = byte(h.opcode)
+	if h.fin {
+		buf[0] |= finBit
+	}
+	if h.rsv1 {
+		buf[0] |= rsv1Bit
+	}
+	if h.rsv2 {
+		buf[0] |= rsv2Bit
+	}
+	if h.rsv3 {
+		buf[0] |= rsv3Bit
+	}
+
+	switch {
+	case h.length > 0xffff:
+		buf[1] = 127
+		binary.BigEndian.PutUint64(buf[2:10], uint64(h.length))
+		n = 10
+	case h.length > 125:
+		buf[1] = 126
+		binary.BigEndian.PutUint16(buf[2:4], uint16(h.length))
+		n = 4
+	default:
+		buf[1] = byte(h.length)
+	}
+
+	if h.masked {
+		buf[1] |= maskBit
+		n += copy(buf[n:], h.maskKey[:])
+	}
+
+	_, err := w.Write(buf[:n])
+	return err
+}
+
+func isControlOpcode(op opcode) bool {
+	return op == opClose || op == opPing || op == opPong
+}
+
+// checkFrameLength reports an error if length exceeds limit, so callers can
+// reject an oversized frame before allocating a buffer sized from an
+// attacker-controlled length. A limit of zero or less means no limit.
+func checkFrameLength(limit, length int64) error {
+	if limit > 0 && length > limit {
+		return fmt.Errorf("websocket: read limit of %d bytes exceeded", limit)
+	}
+	return nil
+}
+
+// maskBytes applies the WebSocket masking algorithm to b in place, starting
+// at the given offset into the logical mask key cycle.
+func maskBytes(key [4]byte, offset int, b []byte) {
+	for i := range b {
+		b[i] ^= key[(offset+i)%4]
+	}
+}
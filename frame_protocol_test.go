@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+)
+
+// TestReaderFailsConnectionOnFramingViolations guards RFC 6455 section 5.2's
+// reserved-bit rule and section 5.1's masking-direction rule: a peer
+// violating either must cause the connection to fail with
+// CloseProtocolError rather than have the frame handed to the application
+// as if it were well-formed.
+func TestReaderFailsConnectionOnFramingViolations(t *testing.T) {
+	tests := []struct {
+		name     string
+		isServer bool
+		header   frameHeader
+	}{
+		{
+			name:     "rsv1 set without negotiated compression",
+			isServer: true,
+			header:   frameHeader{fin: true, rsv1: true, opcode: opText, masked: true, maskKey: [4]byte{1, 2, 3, 4}},
+		},
+		{
+			name:     "rsv2 set",
+			isServer: true,
+			header:   frameHeader{fin: true, rsv2: true, opcode: opText, masked: true, maskKey: [4]byte{1, 2, 3, 4}},
+		},
+		{
+			name:     "server rejects unmasked client frame",
+			isServer: true,
+			header:   frameHeader{fin: true, opcode: opText, masked: false},
+		},
+		{
+			name:     "client rejects masked server frame",
+			isServer: false,
+			header:   frameHeader{fin: true, opcode: opText, masked: true, maskKey: [4]byte{1, 2, 3, 4}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := net.Pipe()
+			defer a.Close()
+			defer b.Close()
+
+			drained := make(chan struct{})
+			go func() {
+				writeFrameHeader(b, tt.header)
+				io.Copy(io.Discard, b)
+				close(drained)
+			}()
+
+			c := newConn(a, tt.isServer, 0, nil)
+			c.br = bufio.NewReader(a)
+			if _, err := c.Reader(context.Background()); err != errInvalidFrame {
+				t.Fatalf("Reader err = %v, want errInvalidFrame", err)
+			}
+
+			a.Close()
+			<-drained
+		})
+	}
+}
+
+// TestReadContinuationHandlesInterleavedPing guards RFC 6455 section 5.4:
+// "an endpoint MUST be capable of handling control frames in the middle of
+// a fragmented message". A ping frame between two fragments of a text
+// message must be dispatched to the ping handler and the message must still
+// be delivered whole, rather than failing the connection with
+// errInvalidFrame.
+func TestReadContinuationHandlesInterleavedPing(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	c := newConn(a, true, 0, nil)
+	c.br = bufio.NewReader(a)
+
+	var pinged int
+	c.SetPingHandler(func(ctx context.Context, appData []byte) error {
+		pinged++
+		return nil
+	})
+
+	go func() {
+		writeMaskedFrame(b, frameHeader{opcode: opText, masked: true, maskKey: [4]byte{1, 2, 3, 4}}, []byte("hello "))
+		writeMaskedFrame(b, frameHeader{fin: true, opcode: opPing, masked: true, maskKey: [4]byte{5, 6, 7, 8}}, []byte("ping-data"))
+		writeMaskedFrame(b, frameHeader{fin: true, opcode: opContinuation, masked: true, maskKey: [4]byte{9, 10, 11, 12}}, []byte("world"))
+	}()
+
+	r, err := c.Reader(context.Background())
+	if err != nil {
+		t.Fatalf("Reader: %v", err)
+	}
+	got, err := io.ReadAll(&r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != "hello world" {
+		t.Fatalf("got %q, want %q", got, "hello world")
+	}
+	if pinged != 1 {
+		t.Fatalf("ping handler called %d time(s), want 1", pinged)
+	}
+}
+
+// writeMaskedFrame masks payload with h.maskKey and writes h (with length
+// filled in from payload) followed by the masked payload to w.
+func writeMaskedFrame(w io.Writer, h frameHeader, payload []byte) error {
+	h.length = int64(len(payload))
+	masked := make([]byte, len(payload))
+	copy(masked, payload)
+	maskBytes(h.maskKey, 0, masked)
+	if err := writeFrameHeader(w, h); err != nil {
+		return err
+	}
+	_, err := w.Write(masked)
+	return err
+}
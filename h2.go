@@ -0,0 +1,253 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// extendedConnectProtocolHeader is the pseudo-header RFC 8441 section 4
+// defines as ":protocol". The net/http2 server surfaces extended CONNECT
+// requests with this value populated in the request's Header under the
+// same key, since Go's http.Header has no representation for pseudo-headers
+// of its own.
+const extendedConnectProtocolHeader = ":protocol"
+
+// isExtendedConnect reports whether r is an RFC 8441 bootstrap request: an
+// HTTP/2 CONNECT with :protocol = websocket.
+func isExtendedConnect(r *http.Request) bool {
+	return r.Method == http.MethodConnect && r.Header.Get(extendedConnectProtocolHeader) == "websocket"
+}
+
+// Protocol returns the underlying HTTP transport the connection was
+// established over: "http/1.1" for a classic Upgrade/Dial handshake or "h2"
+// for an RFC 8441 extended CONNECT bootstrap. On an "h2" Conn, deadlines set
+// via Reader/Writer's SetDeadline close the whole stream when they elapse
+// rather than failing just the one call; see h2StreamConn.SetDeadline for
+// why.
+func (c *Conn) Protocol() string {
+	return c.protocol
+}
+
+// upgradeH2 implements the server side of RFC 8441: instead of running the
+// HTTP/1.1 Upgrade dance, it accepts the extended CONNECT by replying with a
+// 2xx status and then treats the request body and response writer as a
+// bidirectional stream.
+func upgradeH2(w http.ResponseWriter, r *http.Request, responseHeader http.Header, options *UpgradeOptions) (*Conn, error) {
+	fail := func(status int, reason error) (*Conn, error) {
+		if options.Error != nil {
+			options.Error(w, r, status, reason)
+		} else {
+			http.Error(w, reason.Error(), status)
+		}
+		return nil, reason
+	}
+
+	checkOrigin := options.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = checkSameOrigin
+	}
+	if !checkOrigin(r) {
+		return fail(http.StatusForbidden, fmt.Errorf("websocket: request origin not allowed"))
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fail(http.StatusInternalServerError, fmt.Errorf("websocket: response does not implement http.Flusher"))
+	}
+
+	subprotocol := selectSubprotocol(options.Subprotocols, r.Header)
+
+	var compress *compressionParams
+	if options.EnableCompression {
+		if offer, ok := parseExtensionOffers(r.Header, "permessage-deflate"); ok {
+			compress = negotiateCompression(offer, options)
+		}
+	}
+
+	if responseHeader == nil {
+		responseHeader = http.Header{}
+	}
+	if subprotocol != "" {
+		responseHeader.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	if compress != nil {
+		responseHeader.Set("Sec-WebSocket-Extensions", compress.responseHeader())
+	}
+	for k, vs := range responseHeader {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	netConn := &h2StreamConn{body: r.Body, w: w, flusher: flusher, remoteAddr: r.RemoteAddr}
+	c := newConn(netConn, true, options.ReadLimit, compress)
+	c.subprotocol = subprotocol
+	c.protocol = "h2"
+	c.br = bufio.NewReader(r.Body)
+	return c, nil
+}
+
+// dialH2 implements the client side of RFC 8441 using an http2.Transport
+// directly, bypassing net/http's round-tripping so the request body and
+// response body can be driven as a live bidirectional stream for the
+// lifetime of the connection. header carries the same Sec-WebSocket-Protocol
+// and Sec-WebSocket-Extensions offers as the HTTP/1.1 Upgrade path; per RFC
+// 8441 section 5, Sec-WebSocket-Key/-Accept are not used over h2, but
+// subprotocol and extension negotiation still happens through the normal
+// headers. compress is the client's permessage-deflate offer, or nil if
+// EnableCompression was off; it is renegotiated against the server's
+// response the same way the HTTP/1.1 path does.
+func dialH2(ctx context.Context, tr *http2.Transport, u *url.URL, header http.Header, compress *compressionParams, readLimit int64) (*Conn, *http.Response, error) {
+	pr, pw := io.Pipe()
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Scheme: "https", Host: u.Host, Path: u.Path},
+		Proto:  "HTTP/2.0",
+		Header: header.Clone(),
+		Body:   pr,
+	}
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	req.Header.Set(extendedConnectProtocolHeader, "websocket")
+	req = req.WithContext(ctx)
+
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, resp, fmt.Errorf("websocket: bad h2 handshake: status %s", resp.Status)
+	}
+
+	if compress != nil {
+		if offer, ok := parseExtensionOffers(resp.Header, "permessage-deflate"); ok {
+			compress = negotiateCompression(offer, &UpgradeOptions{
+				CompressionLevel:        compress.level,
+				ServerNoContextTakeover: compress.serverNoContextTakeover,
+				ClientNoContextTakeover: compress.clientNoContextTakeover,
+				ServerMaxWindowBits:     compress.serverMaxWindowBits,
+				ClientMaxWindowBits:     compress.clientMaxWindowBits,
+			})
+		} else {
+			compress = nil
+		}
+	}
+
+	netConn := &h2StreamConn{body: resp.Body, w: pipeWriter{pw}, remoteAddr: u.Host}
+	c := newConn(netConn, false, readLimit, compress)
+	c.protocol = "h2"
+	c.subprotocol = resp.Header.Get("Sec-WebSocket-Protocol")
+	c.br = bufio.NewReader(resp.Body)
+	return c, resp, nil
+}
+
+// pipeWriter adapts an *io.PipeWriter, which has no Flush method, to the
+// io.Writer half of h2StreamConn.
+type pipeWriter struct {
+	*io.PipeWriter
+}
+
+// h2StreamConn adapts the request body / response writer pair of an
+// extended CONNECT stream to net.Conn, so it can be handed to newConn like
+// any other transport.
+type h2StreamConn struct {
+	body    io.ReadCloser
+	w       io.Writer
+	flusher http.Flusher
+
+	remoteAddr string
+
+	mu         sync.Mutex
+	readTimer  *time.Timer
+	writeTimer *time.Timer
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (c *h2StreamConn) Read(p []byte) (int, error)  { return c.body.Read(p) }
+func (c *h2StreamConn) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if err == nil && c.flusher != nil {
+		c.flusher.Flush()
+	}
+	return n, err
+}
+func (c *h2StreamConn) Close() error {
+	c.closeOnce.Do(func() {
+		if wc, ok := c.w.(io.Closer); ok {
+			wc.Close()
+		}
+		c.closeErr = c.body.Close()
+	})
+	return c.closeErr
+}
+func (c *h2StreamConn) LocalAddr() net.Addr  { return h2Addr("") }
+func (c *h2StreamConn) RemoteAddr() net.Addr { return h2Addr(c.remoteAddr) }
+
+// SetDeadline, SetReadDeadline, and SetWriteDeadline bound a blocked Read or
+// Write by closing the stream once the deadline elapses, so a Reader's or
+// Writer's SetDeadline calls still have an effect on an h2-bootstrapped
+// Conn. Unlike a real net.Conn,
+// an HTTP/2 stream has no way to abort a single in-flight Read or Write
+// without tearing down the whole stream, so an elapsed deadline here ends
+// the connection rather than just failing the one call; a later call with a
+// fresh deadline cannot revive it. Callers that need the stream to survive
+// a slow read/write should size timeouts accordingly rather than retrying.
+func (c *h2StreamConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *h2StreamConn) SetReadDeadline(t time.Time) error {
+	c.armDeadline(&c.readTimer, t)
+	return nil
+}
+
+func (c *h2StreamConn) SetWriteDeadline(t time.Time) error {
+	c.armDeadline(&c.writeTimer, t)
+	return nil
+}
+
+// armDeadline (re)schedules the timer behind *timer to close the stream at
+// t, canceling any previously scheduled timer first. A zero t disables the
+// deadline.
+func (c *h2StreamConn) armDeadline(timer **time.Timer, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if *timer != nil {
+		(*timer).Stop()
+		*timer = nil
+	}
+	if t.IsZero() {
+		return
+	}
+	d := time.Until(t)
+	if d <= 0 {
+		go c.Close()
+		return
+	}
+	*timer = time.AfterFunc(d, func() { c.Close() })
+}
+
+// h2Addr is a minimal net.Addr for an h2StreamConn, which has no real
+// transport-level address of its own beyond the original request's host.
+type h2Addr string
+
+func (a h2Addr) Network() string { return "h2" }
+func (a h2Addr) String() string  { return string(a) }
@@ -0,0 +1,39 @@
+package websocket
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestH2StreamConnDeadlineBoundsRead guards against a hung or slow h2 peer
+// blocking a Read forever: SetReadDeadline must cause a blocked Read to
+// return once the deadline elapses, even though the underlying stream has
+// no native per-read cancellation and must be closed to unblock it.
+func TestH2StreamConnDeadlineBoundsRead(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	conn := &h2StreamConn{body: pr, w: pw}
+	if err := conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+		t.Fatalf("SetReadDeadline: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Read returned nil error after the deadline elapsed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read did not return after its deadline elapsed")
+	}
+}
+
+var _ net.Conn = (*h2StreamConn)(nil)
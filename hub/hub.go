@@ -0,0 +1,300 @@
+// Package hub provides a broadcast Hub that fans a message out to many
+// WebSocket connections at once, the common "chat server" pattern that
+// ships as an example with comparable libraries.
+package hub
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/StephenYama/websocket"
+)
+
+// OverflowPolicy determines what a Hub does when a connection's outbound
+// queue is full and a new message needs to be delivered to it.
+type OverflowPolicy int
+
+const (
+	// DropOldest discards the oldest queued message to make room for the
+	// new one.
+	DropOldest OverflowPolicy = iota
+
+	// DropNewest discards the message that triggered the overflow, leaving
+	// the queue unchanged.
+	DropNewest
+
+	// CloseSlow unregisters the connection instead of queuing the new
+	// message, as Unregister does: messages already queued are still
+	// written, and the connection itself is not closed.
+	CloseSlow
+)
+
+// defaultQueueSize is the per-connection outbound queue depth used when
+// Config.QueueSize is zero.
+const defaultQueueSize = 16
+
+// Config customizes a Hub.
+type Config struct {
+	// QueueSize bounds the number of messages buffered for each connection
+	// before Overflow applies. Zero selects defaultQueueSize.
+	QueueSize int
+
+	// Overflow selects the policy applied when a connection's queue is
+	// full. The zero value is DropOldest.
+	Overflow OverflowPolicy
+}
+
+func (c *Config) queueSize() int {
+	if c == nil || c.QueueSize == 0 {
+		return defaultQueueSize
+	}
+	return c.QueueSize
+}
+
+func (c *Config) overflow() OverflowPolicy {
+	if c == nil {
+		return DropOldest
+	}
+	return c.Overflow
+}
+
+// message is one queued outbound payload.
+type message struct {
+	data   []byte
+	binary bool
+}
+
+// client is the Hub's bookkeeping for one registered connection.
+type client struct {
+	conn   *websocket.Conn
+	send   chan message
+	topics map[string]struct{}
+
+	closeOnce sync.Once
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.send)
+	})
+}
+
+// Stats reports point-in-time counters for a Hub.
+type Stats struct {
+	// Connections is the number of currently registered connections.
+	Connections int
+
+	// QueueDepth sums the number of messages currently queued across every
+	// connection.
+	QueueDepth int
+
+	// Dropped is the total number of messages discarded by the Hub's
+	// overflow policy since the Hub was created.
+	Dropped int64
+
+	// Closed is the total number of times the CloseSlow overflow policy has
+	// unregistered a connection since the Hub was created.
+	Closed int64
+}
+
+// Hub fans messages out to many registered connections. A slow reader on
+// one connection cannot stall delivery to the others: each connection has
+// its own bounded outbound queue and writer goroutine, and Config.Overflow
+// governs what happens when that queue fills up.
+type Hub struct {
+	queueSize int
+	overflow  OverflowPolicy
+
+	mu      sync.RWMutex
+	clients map[*websocket.Conn]*client
+	topics  map[string]map[*websocket.Conn]struct{}
+
+	dropped int64
+	closed  int64
+}
+
+// New creates a Hub using cfg, or defaults if cfg is nil.
+func New(cfg *Config) *Hub {
+	return &Hub{
+		queueSize: cfg.queueSize(),
+		overflow:  cfg.overflow(),
+		clients:   make(map[*websocket.Conn]*client),
+		topics:    make(map[string]map[*websocket.Conn]struct{}),
+	}
+}
+
+// Register adds conn to the Hub and starts its writer goroutine. Registering
+// the same connection twice is a no-op.
+func (h *Hub) Register(conn *websocket.Conn) {
+	h.mu.Lock()
+	if _, ok := h.clients[conn]; ok {
+		h.mu.Unlock()
+		return
+	}
+	cl := &client{
+		conn:   conn,
+		send:   make(chan message, h.queueSize),
+		topics: make(map[string]struct{}),
+	}
+	h.clients[conn] = cl
+	h.mu.Unlock()
+
+	go h.writeLoop(cl)
+}
+
+// Unregister removes conn from the Hub, every topic it subscribed to, and
+// stops its writer goroutine. It does not close conn itself.
+func (h *Hub) Unregister(conn *websocket.Conn) {
+	h.mu.Lock()
+	cl, ok := h.clients[conn]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.clients, conn)
+	for topic := range cl.topics {
+		if subs, ok := h.topics[topic]; ok {
+			delete(subs, conn)
+			if len(subs) == 0 {
+				delete(h.topics, topic)
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	cl.close()
+}
+
+// SubscribeTopic adds conn to topic, so future calls to BroadcastTopic
+// deliver to it. conn must already be registered.
+func (h *Hub) SubscribeTopic(conn *websocket.Conn, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cl, ok := h.clients[conn]
+	if !ok {
+		return
+	}
+	cl.topics[topic] = struct{}{}
+	subs, ok := h.topics[topic]
+	if !ok {
+		subs = make(map[*websocket.Conn]struct{})
+		h.topics[topic] = subs
+	}
+	subs[conn] = struct{}{}
+}
+
+// UnsubscribeTopic removes conn from topic.
+func (h *Hub) UnsubscribeTopic(conn *websocket.Conn, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if cl, ok := h.clients[conn]; ok {
+		delete(cl.topics, topic)
+	}
+	if subs, ok := h.topics[topic]; ok {
+		delete(subs, conn)
+		if len(subs) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+}
+
+// Broadcast queues msg for delivery to every registered connection.
+func (h *Hub) Broadcast(msg []byte, binary bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for conn, cl := range h.clients {
+		h.enqueue(conn, cl, message{data: msg, binary: binary})
+	}
+}
+
+// BroadcastFunc queues a per-connection payload computed by f for every
+// registered connection, so each recipient can get a customized message
+// (for example with its own viewer-specific fields redacted or filled in).
+// A nil return value from f skips that connection.
+func (h *Hub) BroadcastFunc(f func(*websocket.Conn) ([]byte, bool)) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for conn, cl := range h.clients {
+		data, binary := f(conn)
+		if data == nil {
+			continue
+		}
+		h.enqueue(conn, cl, message{data: data, binary: binary})
+	}
+}
+
+// BroadcastTopic queues msg for delivery to every connection subscribed to
+// topic via SubscribeTopic.
+func (h *Hub) BroadcastTopic(topic string, msg []byte, binary bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for conn := range h.topics[topic] {
+		h.enqueue(conn, h.clients[conn], message{data: msg, binary: binary})
+	}
+}
+
+// enqueue delivers m to cl's outbound queue, applying h.overflow if the
+// queue is full. h.mu must be held (for read or write) by the caller.
+func (h *Hub) enqueue(conn *websocket.Conn, cl *client, m message) {
+	select {
+	case cl.send <- m:
+		return
+	default:
+	}
+
+	switch h.overflow {
+	case DropNewest:
+		atomic.AddInt64(&h.dropped, 1)
+	case CloseSlow:
+		atomic.AddInt64(&h.closed, 1)
+		go h.Unregister(conn)
+	default: // DropOldest
+		select {
+		case <-cl.send:
+			atomic.AddInt64(&h.dropped, 1)
+		default:
+		}
+		select {
+		case cl.send <- m:
+		default:
+			atomic.AddInt64(&h.dropped, 1)
+		}
+	}
+}
+
+// writeLoop drains cl.send and writes each message to cl.conn until the
+// queue is closed by Unregister.
+func (h *Hub) writeLoop(cl *client) {
+	ctx := context.Background()
+	for m := range cl.send {
+		binary := m.binary
+		if err := websocket.WriteBytes(ctx, cl.conn, &websocket.WriteOptions{Binary: binary}, m.data); err != nil {
+			go h.Unregister(cl.conn)
+			return
+		}
+	}
+}
+
+// Stats reports the Hub's current connection count, total queued messages,
+// and lifetime drop/close counters.
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	depth := 0
+	for _, cl := range h.clients {
+		depth += len(cl.send)
+	}
+	return Stats{
+		Connections: len(h.clients),
+		QueueDepth:  depth,
+		Dropped:     atomic.LoadInt64(&h.dropped),
+		Closed:      atomic.LoadInt64(&h.closed),
+	}
+}
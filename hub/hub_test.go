@@ -0,0 +1,116 @@
+package hub
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StephenYama/websocket"
+)
+
+// TestHubBroadcastDeliversMessage is an end-to-end check that Broadcast
+// reaches a connection registered over a real WebSocket handshake.
+func TestHubBroadcastDeliversMessage(t *testing.T) {
+	h := New(nil)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r, nil, nil)
+		if err != nil {
+			t.Errorf("Upgrade: %v", err)
+			return
+		}
+		h.Register(conn)
+	}))
+	defer srv.Close()
+
+	wsURL := "ws://" + strings.TrimPrefix(srv.URL, "http://")
+	conn, _, err := websocket.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.CloseWrite(context.Background(), websocket.CloseNormalClosure, "")
+
+	deadline := time.Now().Add(time.Second)
+	for h.Stats().Connections == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("server never registered the dialed connection")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	h.Broadcast([]byte("hello"), false)
+
+	r, err := conn.Reader(context.Background())
+	if err != nil {
+		t.Fatalf("Reader: %v", err)
+	}
+	got, err := io.ReadAll(&r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("got %q, want %q", got, "hello")
+	}
+}
+
+// TestHubEnqueueOverflowPolicies exercises enqueue directly against a
+// pre-filled queue, since the interesting behavior - what happens once a
+// connection's outbound queue is full - doesn't depend on a live conn.
+func TestHubEnqueueOverflowPolicies(t *testing.T) {
+	t.Run("DropOldest", func(t *testing.T) {
+		h := New(&Config{QueueSize: 2, Overflow: DropOldest})
+		cl := &client{send: make(chan message, 2)}
+		h.clients[nil] = cl
+
+		h.enqueue(nil, cl, message{data: []byte("1")})
+		h.enqueue(nil, cl, message{data: []byte("2")})
+		h.enqueue(nil, cl, message{data: []byte("3")})
+
+		if got := len(cl.send); got != 2 {
+			t.Fatalf("queue depth = %d, want 2", got)
+		}
+		if first := <-cl.send; string(first.data) != "2" {
+			t.Fatalf("oldest message not dropped: head is %q", first.data)
+		}
+		if got := h.Stats().Dropped; got != 1 {
+			t.Fatalf("Dropped = %d, want 1", got)
+		}
+	})
+
+	t.Run("DropNewest", func(t *testing.T) {
+		h := New(&Config{QueueSize: 2, Overflow: DropNewest})
+		cl := &client{send: make(chan message, 2)}
+		h.clients[nil] = cl
+
+		h.enqueue(nil, cl, message{data: []byte("1")})
+		h.enqueue(nil, cl, message{data: []byte("2")})
+		h.enqueue(nil, cl, message{data: []byte("3")})
+
+		if got := len(cl.send); got != 2 {
+			t.Fatalf("queue depth = %d, want 2", got)
+		}
+		if first := <-cl.send; string(first.data) != "1" {
+			t.Fatalf("queue head changed: got %q, want the original oldest message", first.data)
+		}
+		if got := h.Stats().Dropped; got != 1 {
+			t.Fatalf("Dropped = %d, want 1", got)
+		}
+	})
+
+	t.Run("CloseSlow", func(t *testing.T) {
+		h := New(&Config{QueueSize: 1, Overflow: CloseSlow})
+		cl := &client{send: make(chan message, 1)}
+		h.clients[nil] = cl
+
+		h.enqueue(nil, cl, message{data: []byte("1")})
+		h.enqueue(nil, cl, message{data: []byte("2")})
+
+		if got := h.Stats().Closed; got != 1 {
+			t.Fatalf("Closed = %d, want 1", got)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// WriteJSON encodes v as JSON and writes it as a message.
+func WriteJSON(ctx context.Context, c *Conn, options *WriteOptions, v interface{}) error {
+	w, err := c.Writer(ctx)
+	if err != nil {
+		return err
+	}
+	if options != nil {
+		w.SetBinary(options.Binary)
+		if options.Compress != nil {
+			w.SetCompress(*options.Compress)
+		}
+	}
+	w.SetFinal()
+	if err := json.NewEncoder(&w).Encode(v); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// ReadJSON decodes the next received message as JSON to the value pointed to
+// by v.
+func ReadJSON(ctx context.Context, c *Conn, options *ReadOptions, v interface{}) error {
+	r, err := c.Reader(ctx)
+	if err != nil {
+		return err
+	}
+	if options != nil && options.ReadLimit != 0 {
+		r.SetLimit(options.ReadLimit)
+	}
+	dec := json.NewDecoder(&r)
+	if err := dec.Decode(v); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package mux
+
+import "net"
+
+// Listener adapts a server-side Session to the net.Listener interface, so
+// each incoming Stream can be handed to code written against net.Listener
+// (for example http.Serve).
+type Listener struct {
+	session *Session
+}
+
+// Listen returns a Listener that accepts Streams opened by the peer over
+// conn. The underlying connection is treated as the server side of the
+// session; see Server.
+func Listen(conn net.Conn, cfg *Config) *Listener {
+	return &Listener{session: Server(conn, cfg)}
+}
+
+// Accept waits for and returns the next Stream opened by the peer.
+func (l *Listener) Accept() (net.Conn, error) {
+	return l.session.Accept()
+}
+
+// Close closes the underlying Session and every open Stream.
+func (l *Listener) Close() error {
+	return l.session.Close()
+}
+
+// Addr returns the underlying connection's local address.
+func (l *Listener) Addr() net.Addr {
+	return l.session.conn.LocalAddr()
+}
+
+// Dial opens a new Stream over conn, treating conn as the client side of a
+// multiplexed session. Each call starts a new Session (and its read loop) on
+// conn; sessions are not shared between calls. Callers that open many
+// streams over one connection should instead create a Session with Client
+// and call Session.Open directly.
+func Dial(conn net.Conn, cfg *Config) (net.Conn, error) {
+	return Client(conn, cfg).Open()
+}
@@ -0,0 +1,323 @@
+// Package mux multiplexes many logical byte streams over one
+// net.Conn - typically the net.Conn returned by (*websocket.Conn).NetConn -
+// using a small framing header. It lets callers tunnel arbitrary
+// stream-oriented protocols (HTTP, SSH, gRPC) through a single upgraded
+// WebSocket connection.
+package mux
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// Frame flags, carried in the byte following the stream id.
+const (
+	flagSYN byte = 1 << iota // opens a new stream
+	flagFIN                  // half-closes the stream for writing
+	flagRST                  // aborts the stream
+	flagWND                  // payload is a 4-byte big-endian flow-control credit grant
+)
+
+// defaultWindowSize is the initial per-stream send window each side of a
+// Session assumes for a new stream, unless Config.WindowSize overrides it.
+const defaultWindowSize = 256 * 1024
+
+// defaultMaxFrameSize is the largest frame payload a Session will allocate a
+// buffer for, unless Config.MaxFrameSize overrides it.
+const defaultMaxFrameSize = 1 << 20
+
+// ErrSessionClosed is returned by Session methods after the session's
+// underlying connection has been closed.
+var ErrSessionClosed = errors.New("mux: session closed")
+
+// errFrameTooLarge is returned internally by readFrame when a frame's
+// declared length exceeds the session's MaxFrameSize. The offending frame's
+// payload is discarded from the connection (without being buffered) so
+// framing stays in sync, and readLoop resets just that stream rather than
+// tearing down the whole session.
+var errFrameTooLarge = errors.New("mux: frame exceeds max frame size")
+
+// Config customizes a Session.
+type Config struct {
+	// WindowSize sets the per-stream flow-control window. Zero selects
+	// defaultWindowSize.
+	WindowSize uint32
+
+	// AcceptBacklog bounds the number of streams opened by the peer that are
+	// waiting for a call to Session.Accept. Zero selects a reasonable
+	// default.
+	AcceptBacklog int
+
+	// MaxFrameSize bounds the payload size of a single frame read from the
+	// peer. A frame claiming a larger length is discarded and its stream is
+	// reset instead of being allocated; since mux tunnels arbitrary,
+	// potentially untrusted protocols, this guards against a peer driving
+	// unbounded memory use with a single oversized length field. Zero
+	// selects defaultMaxFrameSize.
+	MaxFrameSize uint32
+}
+
+func (c *Config) windowSize() uint32 {
+	if c == nil || c.WindowSize == 0 {
+		return defaultWindowSize
+	}
+	return c.WindowSize
+}
+
+func (c *Config) acceptBacklog() int {
+	if c == nil || c.AcceptBacklog == 0 {
+		return 256
+	}
+	return c.AcceptBacklog
+}
+
+func (c *Config) maxFrameSize() uint32 {
+	if c == nil || c.MaxFrameSize == 0 {
+		return defaultMaxFrameSize
+	}
+	return c.MaxFrameSize
+}
+
+// Session multiplexes Streams over a single underlying net.Conn.
+type Session struct {
+	conn         net.Conn
+	isClient     bool
+	window       uint32
+	maxFrameSize uint32
+
+	br *bufio.Reader
+
+	writeMu sync.Mutex
+
+	mu        sync.Mutex
+	streams   map[uint64]*Stream
+	nextID    uint64
+	accepting chan *Stream
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	closeErr  error
+}
+
+// Client wraps conn as the client (stream-initiating) side of a Session.
+// Stream ids allocated by a client session are odd; ids allocated by a
+// server session are even, so the two sides never collide.
+func Client(conn net.Conn, cfg *Config) *Session {
+	return newSession(conn, true, cfg)
+}
+
+// Server wraps conn as the server (stream-accepting) side of a Session.
+func Server(conn net.Conn, cfg *Config) *Session {
+	return newSession(conn, false, cfg)
+}
+
+func newSession(conn net.Conn, isClient bool, cfg *Config) *Session {
+	s := &Session{
+		conn:         conn,
+		isClient:     isClient,
+		window:       cfg.windowSize(),
+		maxFrameSize: cfg.maxFrameSize(),
+		br:           bufio.NewReader(conn),
+		streams:      make(map[uint64]*Stream),
+		accepting:    make(chan *Stream, cfg.acceptBacklog()),
+		closed:       make(chan struct{}),
+	}
+	if isClient {
+		s.nextID = 1
+	} else {
+		s.nextID = 2
+	}
+	go s.readLoop()
+	return s
+}
+
+// Open creates a new stream and sends the peer a SYN frame.
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	if s.isDone() {
+		s.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := newStream(s, id, s.window)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	if err := s.writeFrame(id, flagSYN, nil); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Accept waits for and returns the next stream opened by the peer.
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case st := <-s.accepting:
+		return st, nil
+	case <-s.closed:
+		return nil, s.closeErrLocked()
+	}
+}
+
+// Close closes the session and every open stream.
+func (s *Session) Close() error {
+	s.closeOnce.Do(func() {
+		s.closeErr = s.conn.Close()
+		s.mu.Lock()
+		for _, st := range s.streams {
+			st.closeWithError(ErrSessionClosed)
+		}
+		s.streams = nil
+		s.mu.Unlock()
+		close(s.closed)
+	})
+	return s.closeErr
+}
+
+func (s *Session) isDone() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Session) closeErrLocked() error {
+	if s.closeErr != nil {
+		return s.closeErr
+	}
+	return ErrSessionClosed
+}
+
+// readLoop demultiplexes frames from the underlying connection and
+// dispatches them to the matching Stream, creating new Streams on receipt of
+// a SYN frame.
+func (s *Session) readLoop() {
+	defer s.Close()
+	for {
+		id, flags, payload, err := readFrame(s.br, s.maxFrameSize)
+		if err == errFrameTooLarge {
+			s.mu.Lock()
+			st := s.streams[id]
+			s.mu.Unlock()
+			if st != nil {
+				st.closeWithError(errFrameTooLarge)
+				s.removeStream(id)
+			}
+			s.writeFrame(id, flagRST, nil)
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		if flags&flagSYN != 0 {
+			s.mu.Lock()
+			st := newStream(s, id, s.window)
+			s.streams[id] = st
+			s.mu.Unlock()
+			select {
+			case s.accepting <- st:
+			case <-s.closed:
+				return
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		st := s.streams[id]
+		s.mu.Unlock()
+		if st == nil {
+			continue
+		}
+
+		switch {
+		case flags&flagRST != 0:
+			st.closeWithError(io.ErrClosedPipe)
+			s.removeStream(id)
+		case flags&flagFIN != 0:
+			if st.closeRemote() {
+				s.removeStream(id)
+			}
+		case flags&flagWND != 0:
+			if len(payload) == 4 {
+				st.windowUpdate(uint32(payload[0])<<24 | uint32(payload[1])<<16 | uint32(payload[2])<<8 | uint32(payload[3]))
+			}
+		default:
+			st.pushData(payload)
+		}
+	}
+}
+
+func (s *Session) removeStream(id uint64) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+func (s *Session) writeFrame(id uint64, flags byte, payload []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return writeFrame(s.conn, id, flags, payload)
+}
+
+// --- wire format ---
+//
+// Each frame is: stream-id (uvarint) | flags (1 byte) | length (uvarint) |
+// payload (length bytes). Flags is a bitmask of flagSYN/flagFIN/flagRST/flagWND;
+// zero flags with a non-empty payload is a plain data frame.
+
+func writeFrame(w io.Writer, id uint64, flags byte, payload []byte) error {
+	var hdr [binary.MaxVarintLen64*2 + 1]byte
+	n := binary.PutUvarint(hdr[:], id)
+	hdr[n] = flags
+	n++
+	n += binary.PutUvarint(hdr[n:], uint64(len(payload)))
+	if _, err := w.Write(hdr[:n]); err != nil {
+		return err
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrame reads one frame from r. If the frame's declared length exceeds
+// maxFrameSize, its payload is discarded from r without being buffered (so
+// the framing stays in sync with the peer) and readFrame returns
+// errFrameTooLarge alongside the frame's id and flags, so the caller can
+// still reset the right stream.
+func readFrame(r *bufio.Reader, maxFrameSize uint32) (id uint64, flags byte, payload []byte, err error) {
+	id, err = binary.ReadUvarint(r)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	flags, err = r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if length > uint64(maxFrameSize) {
+		if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+			return 0, 0, nil, err
+		}
+		return id, flags, nil, errFrameTooLarge
+	}
+	if length > 0 {
+		payload = make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return 0, 0, nil, err
+		}
+	}
+	return id, flags, payload, nil
+}
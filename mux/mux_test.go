@@ -0,0 +1,133 @@
+package mux
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestStreamRemovedAfterGracefulClose guards against a stream leak: once
+// both sides of a Stream have sent (and received) a FIN, the Stream must be
+// dropped from its Session's table, not just on the RST/oversized-frame
+// paths. This is the common case for the tunneling use case mux exists for,
+// since most streams end with a clean FIN/FIN exchange rather than a reset.
+func TestStreamRemovedAfterGracefulClose(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	clientSession := Client(clientConn, nil)
+	serverSession := Server(serverConn, nil)
+	defer clientSession.Close()
+	defer serverSession.Close()
+
+	clientStream, err := clientSession.Open()
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	accepted := make(chan *Stream, 1)
+	go func() {
+		st, err := serverSession.Accept()
+		if err == nil {
+			accepted <- st
+		}
+	}()
+
+	var serverStream *Stream
+	select {
+	case serverStream = <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for Accept")
+	}
+
+	if err := clientStream.Close(); err != nil {
+		t.Fatalf("client Close: %v", err)
+	}
+	if err := serverStream.Close(); err != nil {
+		t.Fatalf("server Close: %v", err)
+	}
+
+	waitForStreamCount(t, clientSession, 0)
+	waitForStreamCount(t, serverSession, 0)
+}
+
+// TestWriteReturnsWhenClosedWhileBlockedOnWindow guards against a Write that
+// is blocked waiting for send-window credit spinning forever once Close
+// races in: Close sets localDone but leaves st.err nil, so without an
+// explicit localDone check the wait loop exits straight into writing
+// zero-length frames in an infinite loop instead of returning an error.
+func TestWriteReturnsWhenClosedWhileBlockedOnWindow(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	cfg := &Config{WindowSize: 4}
+	clientSession := Client(clientConn, cfg)
+	serverSession := Server(serverConn, cfg)
+	defer clientSession.Close()
+	defer serverSession.Close()
+
+	clientStream, err := clientSession.Open()
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	accepted := make(chan *Stream, 1)
+	go func() {
+		st, err := serverSession.Accept()
+		if err == nil {
+			accepted <- st
+		}
+	}()
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for Accept")
+	}
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := clientStream.Write(make([]byte, 64)) // larger than the 4-byte window
+		writeErr <- err
+	}()
+
+	// Give the Write a moment to exhaust the window and start blocking in
+	// waitLocked before Close races in.
+	time.Sleep(20 * time.Millisecond)
+
+	if err := clientStream.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	select {
+	case err := <-writeErr:
+		if err != io.ErrClosedPipe {
+			t.Fatalf("Write err = %v, want io.ErrClosedPipe", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write did not return after Close raced with a blocked Write")
+	}
+}
+
+// waitForStreamCount polls until session tracks exactly n streams, failing
+// the test if that doesn't happen before a short deadline. Polling is
+// needed because the FIN each side just sent is processed asynchronously by
+// the peer's readLoop goroutine.
+func waitForStreamCount(t *testing.T, s *Session, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mu.Lock()
+		got := len(s.streams)
+		s.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("session tracks %d stream(s), want %d", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,239 @@
+package mux
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Stream is a single logical byte stream multiplexed over a Session. Stream
+// implements net.Conn; the Addr methods delegate to the underlying Session
+// connection since streams have no address of their own.
+type Stream struct {
+	id      uint64
+	session *Session
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	readBuf    bytes.Buffer
+	remoteDone bool
+	localDone  bool
+	err        error
+
+	sendWindow uint32
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// errTimeout is returned by Read/Write when a SetDeadline/SetReadDeadline/
+// SetWriteDeadline deadline passes before the call could complete. It
+// implements net.Error so callers doing the usual is-it-a-timeout check
+// still work.
+type errTimeout struct{}
+
+func (errTimeout) Error() string   { return "mux: i/o timeout" }
+func (errTimeout) Timeout() bool   { return true }
+func (errTimeout) Temporary() bool { return true }
+
+func newStream(session *Session, id uint64, window uint32) *Stream {
+	st := &Stream{
+		id:         id,
+		session:    session,
+		sendWindow: window,
+	}
+	st.cond = sync.NewCond(&st.mu)
+	return st
+}
+
+// pushData is called by the Session's read loop when a data frame for this
+// stream arrives.
+func (st *Stream) pushData(p []byte) {
+	st.mu.Lock()
+	st.readBuf.Write(p)
+	st.cond.Broadcast()
+	st.mu.Unlock()
+}
+
+// closeRemote marks the peer's half of the stream as finished (a FIN frame
+// was received); pending buffered data may still be read. It reports whether
+// both halves are now done, so the caller can drop the stream from the
+// Session's table.
+func (st *Stream) closeRemote() (bothDone bool) {
+	st.mu.Lock()
+	st.remoteDone = true
+	bothDone = st.localDone
+	st.cond.Broadcast()
+	st.mu.Unlock()
+	return bothDone
+}
+
+// closeWithError aborts the stream, waking any blocked Read/Write with err.
+func (st *Stream) closeWithError(err error) {
+	st.mu.Lock()
+	if st.err == nil {
+		st.err = err
+	}
+	st.remoteDone = true
+	st.localDone = true
+	st.cond.Broadcast()
+	st.mu.Unlock()
+}
+
+// waitLocked blocks until st.cond is signaled or deadline passes, returning
+// true if deadline had already passed (or passed while waiting). A zero
+// deadline blocks indefinitely. st.mu must be held by the caller, and is
+// held again on return.
+func (st *Stream) waitLocked(deadline time.Time) (timedOut bool) {
+	if deadline.IsZero() {
+		st.cond.Wait()
+		return false
+	}
+	if !time.Now().Before(deadline) {
+		return true
+	}
+	timer := time.AfterFunc(time.Until(deadline), st.cond.Broadcast)
+	st.cond.Wait()
+	timer.Stop()
+	return !time.Now().Before(deadline)
+}
+
+// Read implements io.Reader, blocking until data is available, the peer
+// half-closes the stream, the stream is closed/reset, or ReadDeadline
+// passes.
+func (st *Stream) Read(p []byte) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for st.readBuf.Len() == 0 {
+		if st.err != nil {
+			return 0, st.err
+		}
+		if st.remoteDone {
+			return 0, io.EOF
+		}
+		if st.waitLocked(st.readDeadline) {
+			return 0, errTimeout{}
+		}
+	}
+	n, _ := st.readBuf.Read(p)
+	go st.replenishWindow(uint32(n))
+	return n, nil
+}
+
+// replenishWindow tells the peer it may send n more bytes, restoring the
+// flow-control credit consumed by a Read.
+func (st *Stream) replenishWindow(n uint32) {
+	st.session.writeFrame(st.id, flagWND, windowUpdatePayload(n))
+}
+
+// Write implements io.Writer, splitting p into frames no larger than the
+// remaining send window and blocking until window space is available.
+func (st *Stream) Write(p []byte) (int, error) {
+	st.mu.Lock()
+	if st.localDone || st.err != nil {
+		err := st.err
+		st.mu.Unlock()
+		if err == nil {
+			err = io.ErrClosedPipe
+		}
+		return 0, err
+	}
+	st.mu.Unlock()
+
+	total := 0
+	for len(p) > 0 {
+		st.mu.Lock()
+		for st.sendWindow == 0 && st.err == nil && !st.localDone {
+			if st.waitLocked(st.writeDeadline) {
+				st.mu.Unlock()
+				return total, errTimeout{}
+			}
+		}
+		if st.err != nil {
+			st.mu.Unlock()
+			return total, st.err
+		}
+		if st.localDone {
+			st.mu.Unlock()
+			return total, io.ErrClosedPipe
+		}
+		n := uint32(len(p))
+		if n > st.sendWindow {
+			n = st.sendWindow
+		}
+		st.sendWindow -= n
+		st.mu.Unlock()
+
+		if err := st.session.writeFrame(st.id, 0, p[:n]); err != nil {
+			return total, err
+		}
+		total += int(n)
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// windowUpdate increases the stream's send window, called when the session
+// read loop processes a flagWND frame, whose four-byte big-endian payload is
+// a credit grant rather than application data.
+func (st *Stream) windowUpdate(n uint32) {
+	st.mu.Lock()
+	st.sendWindow += n
+	st.cond.Broadcast()
+	st.mu.Unlock()
+}
+
+// Close half-closes the stream for writing by sending a FIN frame. Once the
+// peer's half is also done, the stream is dropped from the Session's table.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.localDone {
+		st.mu.Unlock()
+		return nil
+	}
+	st.localDone = true
+	bothDone := st.remoteDone
+	st.cond.Broadcast()
+	st.mu.Unlock()
+	if bothDone {
+		st.session.removeStream(st.id)
+	}
+	return st.session.writeFrame(st.id, flagFIN, nil)
+}
+
+// Reset aborts the stream immediately, sending a RST frame to the peer and
+// dropping it from the Session's table.
+func (st *Stream) Reset() error {
+	st.closeWithError(io.ErrClosedPipe)
+	st.session.removeStream(st.id)
+	return st.session.writeFrame(st.id, flagRST, nil)
+}
+
+func (st *Stream) LocalAddr() net.Addr  { return st.session.conn.LocalAddr() }
+func (st *Stream) RemoteAddr() net.Addr { return st.session.conn.RemoteAddr() }
+
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	st.SetWriteDeadline(t)
+	return nil
+}
+
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.mu.Unlock()
+	return nil
+}
+
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDeadline = t
+	st.mu.Unlock()
+	return nil
+}
+
+func windowUpdatePayload(n uint32) []byte {
+	return []byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+}
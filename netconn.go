@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// NetConn adapts the WebSocket connection to the net.Conn interface. Each
+// Write sends p as a single binary message; each Read reads from the
+// current (or next) binary message, returning io.EOF from the underlying
+// Reader only once all messages are exhausted rather than at the end of
+// every individual message. This lets existing stream-oriented code - an
+// io.Copy, a net/rpc codec, anything written against net.Conn - run
+// unmodified over a WebSocket.
+//
+// The returned net.Conn is not safe for concurrent reads, nor for
+// concurrent writes, though a single reader and a single writer may operate
+// concurrently with each other. Do not call Conn's own Reader or Writer
+// methods once NetConn is in use.
+func (c *Conn) NetConn() net.Conn {
+	return &netConnAdapter{c: c, ctx: context.Background()}
+}
+
+// netConnAdapter implements net.Conn on top of a *Conn, keeping the current
+// Reader alive across Read calls until its message is fully consumed.
+type netConnAdapter struct {
+	c   *Conn
+	ctx context.Context
+
+	r     Reader
+	rOpen bool
+}
+
+func (a *netConnAdapter) Read(p []byte) (int, error) {
+	if !a.rOpen {
+		r, err := a.c.Reader(a.ctx)
+		if err != nil {
+			return 0, err
+		}
+		a.r = r
+		a.rOpen = true
+	}
+	n, err := a.r.Read(p)
+	if err == io.EOF {
+		a.rOpen = false
+		if n > 0 {
+			return n, nil
+		}
+		return a.Read(p)
+	}
+	return n, err
+}
+
+func (a *netConnAdapter) Write(p []byte) (int, error) {
+	w, err := a.c.Writer(a.ctx)
+	if err != nil {
+		return 0, err
+	}
+	w.SetBinary(true)
+	w.SetFinal()
+	n, err := w.Write(p)
+	if err != nil {
+		w.Close()
+		return n, err
+	}
+	return n, w.Close()
+}
+
+func (a *netConnAdapter) Close() error {
+	return a.c.close()
+}
+
+func (a *netConnAdapter) LocalAddr() net.Addr  { return a.c.conn.LocalAddr() }
+func (a *netConnAdapter) RemoteAddr() net.Addr { return a.c.conn.RemoteAddr() }
+
+// SetDeadline forwards the deadline to the underlying network connection for
+// both reads and writes.
+func (a *netConnAdapter) SetDeadline(t time.Time) error {
+	if err := a.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return a.SetWriteDeadline(t)
+}
+
+func (a *netConnAdapter) SetReadDeadline(t time.Time) error {
+	return a.c.conn.SetReadDeadline(t)
+}
+
+func (a *netConnAdapter) SetWriteDeadline(t time.Time) error {
+	return a.c.conn.SetWriteDeadline(t)
+}
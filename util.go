@@ -0,0 +1,25 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"net/http"
+)
+
+// randRead fills b with cryptographically random bytes, used for frame
+// masking keys. It panics if the system CSPRNG is unavailable, which mirrors
+// the behavior of crypto/rand.Read itself.
+func randRead(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+}
+
+// parseOriginURL returns the host (including port, if any) of the Origin
+// header value.
+func parseOriginURL(origin string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, origin, nil)
+	if err != nil {
+		return "", err
+	}
+	return req.Host, nil
+}
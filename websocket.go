@@ -20,11 +20,25 @@
 //
 package websocket
 
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
 // CloseCode is a WebSocket close code.
 type CloseCode int
 
-//go:generate go run golang.org/x/tools/cmd/stringer -type=StatusCode
-
 // Close codes defined in RFC 6455, section 11.7.
 const (
 	CloseNormalClosure           CloseCode = 1000
@@ -46,16 +60,24 @@ const (
 // CloseError represents a close message received from a peer.
 type CloseError struct {
 	// Code is defined in RFC 6455, section 11.7.
-	Code int
+	Code CloseCode
 
 	// Reason is the optional text payload.
 	Reason string
 }
 
 func (e *CloseError) Error() string {
-	return fmt.Printf("websocket close: code = %v (%d), message = %s", e.Code, e.Code, e.Text)
+	return fmt.Sprintf("websocket close: code = %d, reason = %q", e.Code, e.Reason)
 }
 
+// defaultReadLimit is used when UpgradeOptions.ReadLimit and
+// Dialer.ReadLimit are left at zero.
+const defaultReadLimit = 32 * 1024
+
+// websocketGUID is appended to the client's Sec-WebSocket-Key before hashing
+// to produce Sec-WebSocket-Accept, per RFC 6455 section 1.3.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
 // UpgradeOptions specifies options for upgrading an HTTP connection to a
 // WebSocket connection.
 type UpgradeOptions struct {
@@ -63,7 +85,6 @@ type UpgradeOptions struct {
 	// Error is nil, then http.Error is used to generate the HTTP response.
 	Error func(w http.ResponseWriter, r *http.Request, status int, reason error)
 
-	//
 	// CheckOrigin returns true if the request Origin header is acceptable. If
 	// CheckOrigin is nil, then a safe default is used: return false if the
 	// Origin request header is present and the origin host is not equal to
@@ -71,7 +92,7 @@ type UpgradeOptions struct {
 	//
 	// A CheckOrigin function should carefully validate the request origin to
 	// prevent cross-site request forgery.
-	OriginTestHandledByApplication bool
+	CheckOrigin func(r *http.Request) bool
 
 	// Subprotocols specifies the server's supported protocols in order of
 	// preference. If this field is not nil, then the Upgrade method negotiates
@@ -106,6 +127,33 @@ type UpgradeOptions struct {
 	// time that Conn.Writer returns a writer. A call to Reader.SetDeadline
 	// overrides this timeout.
 	WriteTimeout time.Duration
+
+	// EnableCompression specifies whether the server should offer and accept
+	// the permessage-deflate extension (RFC 7692). The default is false.
+	EnableCompression bool
+
+	// CompressionLevel is passed to flate.NewWriter for outgoing compressed
+	// messages. It defaults to flate.DefaultCompression.
+	CompressionLevel int
+
+	// ServerNoContextTakeover requests that the server reset its compression
+	// state between messages instead of carrying the sliding window forward.
+	// This trades compression ratio for lower memory use.
+	ServerNoContextTakeover bool
+
+	// ClientNoContextTakeover requests that the client reset its compression
+	// state between messages.
+	ClientNoContextTakeover bool
+
+	// ServerMaxWindowBits bounds the size of the LZ77 sliding window the
+	// server uses when compressing messages it sends. Valid values are 8-15;
+	// zero means no preference is advertised.
+	ServerMaxWindowBits int
+
+	// ClientMaxWindowBits bounds the size of the LZ77 sliding window the
+	// client is permitted to use when compressing messages it sends. Valid
+	// values are 8-15; zero means no preference is advertised.
+	ClientMaxWindowBits int
 }
 
 // Upgrade upgrades the HTTP server connection to the WebSocket protocol.
@@ -116,38 +164,537 @@ type UpgradeOptions struct {
 //
 // If the upgrade fails, then Upgrade replies to the client with an HTTP error
 // response.
-func Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header, options *UpgradeOptions) (*Conn, error)
+//
+// If r is an HTTP/2 extended CONNECT request per RFC 8441 (Method ==
+// "CONNECT" with the :protocol pseudo-header set to "websocket"), Upgrade
+// bootstraps over that instead of running the HTTP/1.1 Upgrade dance:
+// Sec-WebSocket-Key/Sec-WebSocket-Accept are skipped since 8441 does not use
+// them, but subprotocols and extensions are still negotiated from the usual
+// headers. Conn.Protocol reports which transport was used.
+func Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header, options *UpgradeOptions) (*Conn, error) {
+	if options == nil {
+		options = &UpgradeOptions{}
+	}
+
+	if isExtendedConnect(r) {
+		return upgradeH2(w, r, responseHeader, options)
+	}
+
+	fail := func(status int, reason error) (*Conn, error) {
+		if options.Error != nil {
+			options.Error(w, r, status, reason)
+		} else {
+			http.Error(w, reason.Error(), status)
+		}
+		return nil, reason
+	}
+
+	if !strings.EqualFold(r.Header.Get("Connection"), "upgrade") && !headerContainsToken(r.Header, "Connection", "upgrade") {
+		return fail(http.StatusBadRequest, fmt.Errorf("websocket: not a websocket handshake: 'Connection' header missing 'Upgrade' token"))
+	}
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return fail(http.StatusBadRequest, fmt.Errorf("websocket: not a websocket handshake: 'Upgrade' header missing 'websocket' token"))
+	}
+	if r.Method != http.MethodGet {
+		return fail(http.StatusMethodNotAllowed, fmt.Errorf("websocket: method %q is not GET", r.Method))
+	}
+
+	checkOrigin := options.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = checkSameOrigin
+	}
+	if !checkOrigin(r) {
+		return fail(http.StatusForbidden, fmt.Errorf("websocket: request origin not allowed"))
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return fail(http.StatusBadRequest, fmt.Errorf("websocket: not a websocket handshake: 'Sec-WebSocket-Key' header missing"))
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return fail(http.StatusInternalServerError, fmt.Errorf("websocket: response does not implement http.Hijacker"))
+	}
+	netConn, brw, err := hj.Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, err)
+	}
+
+	subprotocol := selectSubprotocol(options.Subprotocols, r.Header)
+
+	var extResp string
+	var compress *compressionParams
+	if options.EnableCompression {
+		if offer, ok := parseExtensionOffers(r.Header, "permessage-deflate"); ok {
+			compress = negotiateCompression(offer, options)
+			extResp = compress.responseHeader()
+		}
+	}
+
+	if responseHeader == nil {
+		responseHeader = http.Header{}
+	}
+
+	accept := computeAcceptKey(key)
+
+	var buf strings.Builder
+	buf.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	buf.WriteString("Upgrade: websocket\r\n")
+	buf.WriteString("Connection: Upgrade\r\n")
+	buf.WriteString("Sec-WebSocket-Accept: " + accept + "\r\n")
+	if subprotocol != "" {
+		buf.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	if extResp != "" {
+		buf.WriteString("Sec-WebSocket-Extensions: " + extResp + "\r\n")
+	}
+	for k, vs := range responseHeader {
+		for _, v := range vs {
+			buf.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	buf.WriteString("\r\n")
+
+	if _, err := brw.WriteString(buf.String()); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	if err := brw.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	c := newConn(netConn, true, options.ReadLimit, compress)
+	c.subprotocol = subprotocol
+	c.br = brw.Reader
+	return c, nil
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h[textproto.CanonicalMIMEHeaderKey(name)] {
+		for _, p := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(p), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func selectSubprotocol(serverProtocols []string, h http.Header) string {
+	if len(serverProtocols) == 0 {
+		return ""
+	}
+	clientProtocols := map[string]bool{}
+	for _, v := range h["Sec-Websocket-Protocol"] {
+		for _, p := range strings.Split(v, ",") {
+			clientProtocols[strings.TrimSpace(p)] = true
+		}
+	}
+	for _, p := range serverProtocols {
+		if clientProtocols[p] {
+			return p
+		}
+	}
+	return ""
+}
+
+func checkSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := parseOriginURL(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u, r.Host)
+}
+
+func computeAcceptKey(key string) string {
+	h := sha1.New()
+	io.WriteString(h, key)
+	io.WriteString(h, websocketGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
 
-// The Conn type represents a WebSocket connection.
+// Conn represents a WebSocket connection.
 type Conn struct {
+	conn     net.Conn
+	isServer bool
+
+	br *bufio.Reader
+	bw *bufio.Writer
+
+	subprotocol string
+	protocol    string
+
+	readMu    sync.Mutex
+	readLimit int64
+
+	writeMu sync.Mutex
+
+	compress  *compressionParams
+	writeDict []byte
+	readDict  []byte
+
+	handlersMu   sync.RWMutex
+	pingHandler  func(ctx context.Context, appData []byte) error
+	pongHandler  func(appData []byte) error
+	closeHandler func(code CloseCode, text string) error
+
+	parentCtx context.Context
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newConn(netConn net.Conn, isServer bool, readLimit int64, compress *compressionParams) *Conn {
+	if readLimit == 0 {
+		readLimit = defaultReadLimit
+	}
+	c := &Conn{
+		conn:      netConn,
+		isServer:  isServer,
+		bw:        bufio.NewWriter(netConn),
+		readLimit: readLimit,
+		compress:  compress,
+		protocol:  "http/1.1",
+		closed:    make(chan struct{}),
+	}
+	c.pingHandler = c.defaultPingHandler
+	c.pongHandler = func(appData []byte) error { return nil }
+	c.closeHandler = c.defaultCloseHandler
+	return c
 }
 
 // Subprotocol returns the negotiated protocol for the connection.
-func (c *Conn) Subprotocol() string {}
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// SetParentContext sets a parent context for the connection. The connection
+// is closed when the parent context is canceled.
+func (c *Conn) SetParentContext(ctx context.Context) {
+	c.parentCtx = ctx
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.Close()
+		case <-c.closed:
+		}
+	}()
+}
 
-// StartClose initiates the WebSocket closing handshake and arranges for
+// CloseWrite initiates the WebSocket closing handshake and arranges for
 // Reader to timeout if a timely reply is not received. The application must
 // call Reader to complete the closing handshake and close the connection.
 //
 // See the package documentation for more information on the closing handshake.
-func (c *Conn) CloseWrite(ctx context.Context, code CloseCode, message string) error {}
+func (c *Conn) CloseWrite(ctx context.Context, code CloseCode, message string) error {
+	payload := make([]byte, 2+len(message))
+	payload[0] = byte(code >> 8)
+	payload[1] = byte(code)
+	copy(payload[2:], message)
+	return c.writeControl(opClose, payload)
+}
 
-// SetParentContext sets a parent context for the connection. The connection is
-// closed when the parent context is canceled.
-func (c *Conn) SetParentContextContext(ctx context.Context) context.Context {}
+func (c *Conn) close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// checkFrameHeader validates the invariants RFC 6455 requires of every
+// frame regardless of opcode. Section 5.2 requires RSV1-3 to be zero unless
+// an extension negotiated during the handshake defines their meaning; the
+// only extension this package negotiates is permessage-deflate, which uses
+// RSV1, so RSV1 is only legal when compression was negotiated. Section 5.1
+// requires the server to reject unmasked frames from a client and the
+// client to reject masked frames from a server.
+func (c *Conn) checkFrameHeader(h frameHeader) error {
+	if h.rsv2 || h.rsv3 || (h.rsv1 && c.compress == nil) {
+		return errInvalidFrame
+	}
+	if h.masked != c.isServer {
+		return errInvalidFrame
+	}
+	return nil
+}
+
+// failConnection implements the RFC 6455 "Fail the WebSocket Connection"
+// behavior: it sends a Close frame carrying CloseProtocolError and tears
+// down the underlying connection without waiting for the peer's reply,
+// since a peer that already violated framing cannot be trusted to
+// complete the closing handshake cleanly.
+func (c *Conn) failConnection() {
+	c.CloseWrite(context.Background(), CloseProtocolError, "")
+	c.close()
+}
+
+func (c *Conn) defaultPingHandler(ctx context.Context, appData []byte) error {
+	return c.WritePong(ctx, appData)
+}
+
+func (c *Conn) defaultCloseHandler(code CloseCode, text string) error {
+	c.CloseWrite(context.Background(), CloseNormalClosure, "")
+	return c.close()
+}
 
 // Reader returns a Reader on the next data message received from the peer.
 //
-// Control messages are handled internerally by connection. The application must
-// call Reaader in a loop to process these messages.
+// Control messages are handled internally by the connection. The application
+// must call Reader in a loop to process these messages.
 //
 // The application must read each Reader until io.EOF or some other error is
 // returned.
-func (c *Conn) Reader(ctx context.Context) (Reader, error) { return Reader{}, nil }
+func (c *Conn) Reader(ctx context.Context) (Reader, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for {
+		h, err := readFrameHeader(c.br)
+		if err != nil {
+			return Reader{}, err
+		}
+
+		if err := c.checkFrameHeader(h); err != nil {
+			c.failConnection()
+			return Reader{}, err
+		}
+
+		if err := checkFrameLength(c.readLimit, h.length); err != nil {
+			return Reader{}, err
+		}
+
+		payload, err := readFramePayload(c.br, h)
+		if err != nil {
+			return Reader{}, err
+		}
+
+		if isControlOpcode(h.opcode) {
+			if err := c.handleControlFrame(ctx, h, payload); err != nil {
+				return Reader{}, err
+			}
+			continue
+		}
+
+		switch h.opcode {
+		case opText, opBinary:
+			return c.newMessageReader(ctx, h, payload)
+		default:
+			return Reader{}, errInvalidFrame
+		}
+	}
+}
+
+// readFramePayload reads the length-prefixed payload described by h from r,
+// unmasking it in place if h.masked. It reports a nil, nil payload for a
+// zero-length frame.
+func readFramePayload(r io.Reader, h frameHeader) ([]byte, error) {
+	if h.length == 0 {
+		return nil, nil
+	}
+	payload := make([]byte, h.length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if h.masked {
+		maskBytes(h.maskKey, 0, payload)
+	}
+	return payload, nil
+}
+
+// handleControlFrame dispatches a ping, pong, or close frame to the
+// connection's installed handlers. It is shared between the top-level
+// Reader loop and readContinuation, since RFC 6455 section 5.4 requires
+// that control frames be handled wherever they appear, including
+// interleaved between the fragments of another message.
+func (c *Conn) handleControlFrame(ctx context.Context, h frameHeader, payload []byte) error {
+	switch h.opcode {
+	case opPing:
+		return c.callPingHandler(ctx, payload)
+	case opPong:
+		return c.callPongHandler(payload)
+	case opClose:
+		code := CloseNoStatusReceived
+		text := ""
+		if len(payload) >= 2 {
+			code = CloseCode(int(payload[0])<<8 | int(payload[1]))
+			text = string(payload[2:])
+		}
+		if err := c.callCloseHandler(code, text); err != nil {
+			return err
+		}
+		return &CloseError{Code: code, Reason: text}
+	default:
+		return errInvalidFrame
+	}
+}
 
 // Writer returns a message writer. The application must close the writer when
 // done writing the message.
-func (c *Conn) Writer(ctx context.Context) (Writer, error) { return Writer{}, nil }
+func (c *Conn) Writer(ctx context.Context) (Writer, error) {
+	return Writer{c: c, binary: false, compress: c.compress != nil}, nil
+}
+
+// WritePing sends a ping control frame with the given application data to
+// the peer. Applications can use WritePing together with SetPongHandler to
+// drive their own liveness protocol on top of the connection.
+func (c *Conn) WritePing(ctx context.Context, data []byte) error {
+	return c.writeControl(opPing, data)
+}
+
+// WritePong sends a pong control frame with the given application data to
+// the peer.
+func (c *Conn) WritePong(ctx context.Context, data []byte) error {
+	return c.writeControl(opPong, data)
+}
+
+func (c *Conn) writeControl(op opcode, data []byte) error {
+	if len(data) > maxControlFramePayload {
+		return fmt.Errorf("websocket: control frame payload exceeds %d bytes", maxControlFramePayload)
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	h := frameHeader{fin: true, opcode: op, length: int64(len(data)), masked: !c.isServer}
+	if h.masked {
+		h.maskKey = newMaskKey()
+		masked := make([]byte, len(data))
+		copy(masked, data)
+		maskBytes(h.maskKey, 0, masked)
+		data = masked
+	}
+	if err := writeFrameHeader(c.bw, h); err != nil {
+		return err
+	}
+	if _, err := c.bw.Write(data); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+// PingRTT sends a ping carrying a random nonce and blocks until the matching
+// pong is observed or ctx is done, returning the measured round-trip time.
+// It composes with any handler installed via SetPongHandler, which still
+// runs for every pong, including the one PingRTT is waiting for. As with
+// other control-message handling, a pong is only observed while the
+// application is calling Reader. PingRTT must not be called concurrently
+// with itself on the same Conn.
+func (c *Conn) PingRTT(ctx context.Context) (time.Duration, error) {
+	nonce := make([]byte, 8)
+	randRead(nonce)
+
+	matched := make(chan time.Time, 1)
+
+	c.handlersMu.Lock()
+	prev := c.pongHandler
+	c.pongHandler = func(appData []byte) error {
+		if bytes.Equal(appData, nonce) {
+			select {
+			case matched <- time.Now():
+			default:
+			}
+		}
+		return prev(appData)
+	}
+	c.handlersMu.Unlock()
+
+	defer func() {
+		c.handlersMu.Lock()
+		c.pongHandler = prev
+		c.handlersMu.Unlock()
+	}()
+
+	start := time.Now()
+	if err := c.WritePing(ctx, nonce); err != nil {
+		return 0, err
+	}
+
+	select {
+	case t := <-matched:
+		return t.Sub(start), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-c.closed:
+		return 0, fmt.Errorf("websocket: connection closed while waiting for pong")
+	}
+}
+
+// SetPingHandler sets the function called when a ping message is received
+// from the peer, for example to implement custom keepalive metrics or RTT
+// measurement. The default ping handler sends a pong to the peer.
+//
+// The appData argument to h is the control frame application data. SetPingHandler
+// may be called concurrently with Reader.
+func (c *Conn) SetPingHandler(h func(ctx context.Context, appData []byte) error) {
+	if h == nil {
+		h = c.defaultPingHandler
+	}
+	c.handlersMu.Lock()
+	c.pingHandler = h
+	c.handlersMu.Unlock()
+}
+
+// SetPongHandler sets the function called when a pong message is received
+// from the peer, for example to measure round-trip time for an
+// application-driven ping. The default pong handler does nothing.
+//
+// SetPongHandler may be called concurrently with Reader.
+func (c *Conn) SetPongHandler(h func(appData []byte) error) {
+	if h == nil {
+		h = func(appData []byte) error { return nil }
+	}
+	c.handlersMu.Lock()
+	c.pongHandler = h
+	c.handlersMu.Unlock()
+}
+
+// SetCloseHandler sets the function called when a close message is received
+// from the peer, for example to translate close codes into
+// application-specific errors. The default close handler sends a close
+// message back to the peer and closes the underlying network connection.
+//
+// SetCloseHandler may be called concurrently with Reader.
+func (c *Conn) SetCloseHandler(h func(code CloseCode, text string) error) {
+	if h == nil {
+		h = c.defaultCloseHandler
+	}
+	c.handlersMu.Lock()
+	c.closeHandler = h
+	c.handlersMu.Unlock()
+}
+
+func (c *Conn) callPingHandler(ctx context.Context, appData []byte) error {
+	c.handlersMu.RLock()
+	h := c.pingHandler
+	c.handlersMu.RUnlock()
+	return h(ctx, appData)
+}
+
+func (c *Conn) callPongHandler(appData []byte) error {
+	c.handlersMu.RLock()
+	h := c.pongHandler
+	c.handlersMu.RUnlock()
+	return h(appData)
+}
+
+func (c *Conn) callCloseHandler(code CloseCode, text string) error {
+	c.handlersMu.RLock()
+	h := c.closeHandler
+	c.handlersMu.RUnlock()
+	return h(code, text)
+}
+
+func newMaskKey() [4]byte {
+	var key [4]byte
+	randRead(key[:])
+	return key
+}
 
 // Writer writes a message to the peer. Writer satisfies the io.Writer
 // interface.
@@ -192,88 +739,373 @@ func (c *Conn) Writer(ctx context.Context) (Writer, error) { return Writer{}, ni
 //      // handle error
 //  }
 type Writer struct {
-	c     *Conn
-	nonce int64
+	c          *Conn
+	nonce      int64
+	binary     bool
+	compress   bool
+	final      bool
+	started    bool
+	finished   bool
+	deflate     io.WriteCloser
+	deflateDict bool
+	deflateBuf  *flateBuffer
+	rawAccum    []byte
 }
 
-// Close ensures that final message frame is written to the network and
-// releases resources used by the Writer.
+// Close ensures that the final message frame is written to the network and
+// releases resources used by the Writer. If a prior call to Write already
+// sent the final frame (see SetFinal), Close writes nothing further.
 //
 // The application must close each writer.
-func (w Writer) Close() error { return nil }
+func (w *Writer) Close() error {
+	if w.finished {
+		return nil
+	}
+	return w.writeFrame(nil, true)
+}
 
 // SetBinary marks the message as a binary data message. Otherwise, the message
 // is assumed to be a valid UTF-8 encoded text. SetBinary must be called before
 // the first call to Write.
-func (w Writer) SetBinary(binary bool) {}
+func (w *Writer) SetBinary(binary bool) {
+	w.binary = binary
+}
 
-// SetCompress determines whether the message is compressed when compression is
-// negotiated with the peer. SetCompress must be called before the first call
-// to Write. The default is to compress messages.
-func (w Writer) SetCompress(compress bool) {}
+// SetCompress determines whether the message is compressed when compression
+// is negotiated with the peer. SetCompress must be called before the first
+// call to Write. The default is to compress messages.
+func (w *Writer) SetCompress(compress bool) {
+	w.compress = compress && w.c.compress != nil
+}
 
 // SetDeadline sets the deadline for future Write calls. A zero value for t
 // means Write will not time out. SetDeadline overrides the timeout specified
-// in DialOptions.MessageWriteTimeout and UpgradeOptions.MessageWriteTimeout.
-func (w Writer) SetDeadline(t time.Time) error { return nil }
+// in UpgradeOptions.WriteTimeout.
+func (w *Writer) SetDeadline(t time.Time) error {
+	return w.c.conn.SetWriteDeadline(t)
+}
 
 // Write writes p to the message. It returns the number of bytes written from p
 // (0 <= n <= len(p)) and any error encountered that caused the write to stop
 // early.
-func (w Writer) Write(p []byte) (int, error) {}
+func (w *Writer) Write(p []byte) (int, error) {
+	if err := w.writeFrame(p, w.final); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
 
-// WriteString writes s to the message. It returns the number of bytes written from s
-// (0 <= n <= len(s)) and any error encountered that caused the write to stop
-// early.
-func (w Writer) WriteString(s string) (int, error) {}
+// WriteString writes s to the message. It returns the number of bytes written
+// from s (0 <= n <= len(s)) and any error encountered that caused the write to
+// stop early.
+func (w *Writer) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
 
 // SetFinal sets the final flag on the frame written by the next call to Write.
-// This method optmizes the data written to the network. Applications do not
+// This method optimizes the data written to the network. Applications do not
 // need to call this method.
-func (w Writer) SetFinal() {}
+func (w *Writer) SetFinal() {
+	w.final = true
+}
+
+func (w *Writer) writeFrame(p []byte, last bool) error {
+	c := w.c
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	op := opBinary
+	if !w.binary {
+		op = opText
+	}
+	if w.started {
+		op = opContinuation
+	}
+
+	rsv1 := false
+	if w.compress {
+		if w.deflate == nil {
+			w.deflateBuf = getFlateBuffer()
+			dict := c.writeDict
+			if c.compress.writeNoContextTakeover(c.isServer) {
+				dict = nil
+			}
+			w.deflate = newFlateWriter(w.deflateBuf, c.compress.level, dict)
+			w.deflateDict = len(dict) > 0
+		}
+		w.rawAccum = append(w.rawAccum, p...)
+		if _, err := w.deflate.Write(p); err != nil {
+			return err
+		}
+		if last {
+			w.deflate.Close()
+			trimDeflateTail(w.deflateBuf)
+		} else {
+			w.deflateBuf.Flush(w.deflate)
+		}
+		p = w.deflateBuf.Bytes()
+		w.deflateBuf.Reset()
+		rsv1 = true
+	}
+
+	h := frameHeader{fin: last, rsv1: rsv1 && !w.started, opcode: op, length: int64(len(p)), masked: !c.isServer}
+	if h.masked {
+		h.maskKey = newMaskKey()
+		masked := make([]byte, len(p))
+		copy(masked, p)
+		maskBytes(h.maskKey, 0, masked)
+		p = masked
+	}
+	if err := writeFrameHeader(c.bw, h); err != nil {
+		return err
+	}
+	if len(p) > 0 {
+		if _, err := c.bw.Write(p); err != nil {
+			return err
+		}
+	}
+	w.started = true
+	if last {
+		w.finished = true
+		if w.compress {
+			if c.compress.writeNoContextTakeover(c.isServer) {
+				c.writeDict = nil
+			} else {
+				c.writeDict = appendWindow(c.writeDict, w.rawAccum, c.compress.writeWindowSize(c.isServer))
+			}
+			// A writer seeded with a preset dictionary via newFlateWriter must
+			// not be pooled: flate.Writer.Reset keeps reusing the dictionary it
+			// was constructed with, so handing this one to a future
+			// no-dictionary message would silently corrupt that message's
+			// stream with this message's leftover context.
+			if !w.deflateDict {
+				putFlateWriter(w.deflate)
+			}
+			putFlateBuffer(w.deflateBuf)
+			w.deflate = nil
+			w.deflateDict = false
+			w.deflateBuf = nil
+		}
+		return c.bw.Flush()
+	}
+	return nil
+}
+
+// frameState holds the continuation-frame state of an in-progress message.
+// It is held by pointer (rather than embedded by value) so that it can be
+// shared between a Reader and the inflate reader built on top of it: Reader
+// values are returned from newMessageReader and then copied into the
+// caller's variable, so a pointer into a Reader captured before that copy
+// would reference a stale copy once the message reading proceeds.
+type frameState struct {
+	// ctx is the context the application passed to Conn.Reader when this
+	// message's Reader was created. readContinuation reuses it to dispatch
+	// any ping frame interleaved between this message's fragments, since a
+	// mid-message Read has no context of its own to pass through.
+	ctx     context.Context
+	pending []byte
+	done    bool
+}
 
-// Reader reads a message from the peer. MessageReader satisifies the io.Reader
+// Reader reads a message from the peer. Reader satisfies the io.Reader
 // interface.
 type Reader struct {
-	c     *Conn
-	nonce int64
+	c         *Conn
+	nonce     int64
+	binary    bool
+	state     *frameState
+	compress  bool
+	inflate   io.ReadCloser
+	read      int64
+	limit     int64
+	dictAccum []byte
+}
+
+func (c *Conn) newMessageReader(ctx context.Context, h frameHeader, payload []byte) (Reader, error) {
+	st := &frameState{ctx: ctx, pending: payload, done: h.fin}
+	r := Reader{c: c, binary: h.opcode == opBinary, compress: h.rsv1 && c.compress != nil, limit: c.readLimit, state: st}
+	if r.compress {
+		dict := c.readDict
+		if c.compress.readNoContextTakeover(c.isServer) {
+			dict = nil
+		}
+		r.inflate = newFlateReader(c, st, c.readLimit, dict)
+	}
+	return r, nil
+}
+
+// commitReadDict folds a fully-read compressed message's decompressed bytes
+// into the connection's read-side dictionary, so the next message's
+// decompressor can be seeded with it as if the peer's compression context
+// had been carried over. If the peer negotiated no_context_takeover for
+// this side, the dictionary is discarded instead.
+func (c *Conn) commitReadDict(msg []byte) {
+	if c.compress.readNoContextTakeover(c.isServer) {
+		c.readDict = nil
+		return
+	}
+	c.readDict = appendWindow(c.readDict, msg, c.compress.readWindowSize(c.isServer))
 }
 
 // Binary returns true if the message is a WebSocket binary message.
 // Otherwise, the message is a WebSocket TextMessage.
-func (r Reader) Binary() bool {}
+func (r *Reader) Binary() bool {
+	return r.binary
+}
+
+// readContinuation reads the next frame of the message described by st,
+// following continuation frames on c until the final frame is consumed. It
+// is a free function rather than a Reader method so that it can also serve
+// as the source for the (optional) inflate reader, which must outlive the
+// Reader value it was built from.
+//
+// RFC 6455 section 5.4 requires that an endpoint handle control frames
+// interleaved between the fragments of a message, so a ping/pong/close
+// frame encountered here is dispatched the same way as at the top level of
+// Conn.Reader rather than failing the connection.
+func readContinuation(c *Conn, st *frameState, limit int64, p []byte) (int, error) {
+	for len(st.pending) == 0 {
+		if st.done {
+			return 0, io.EOF
+		}
+		h, err := readFrameHeader(c.br)
+		if err != nil {
+			return 0, err
+		}
+		if err := c.checkFrameHeader(h); err != nil {
+			c.failConnection()
+			return 0, err
+		}
+		if err := checkFrameLength(limit, h.length); err != nil {
+			return 0, err
+		}
+		payload, err := readFramePayload(c.br, h)
+		if err != nil {
+			return 0, err
+		}
+		if isControlOpcode(h.opcode) {
+			if err := c.handleControlFrame(st.ctx, h, payload); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if h.opcode != opContinuation {
+			return 0, errInvalidFrame
+		}
+		st.pending = payload
+		st.done = h.fin
+	}
+	n := copy(p, st.pending)
+	st.pending = st.pending[n:]
+	return n, nil
+}
+
+func (r *Reader) rawRead(p []byte) (int, error) {
+	return readContinuation(r.c, r.state, r.c.readLimit, p)
+}
 
 // Read reads up to len(p) bytes into p. It returns the number of bytes read (0
 // <= n <= len(p)) and any error encountered.
-func (r Reader) Read(p []byte) (int, error) {}
+func (r *Reader) Read(p []byte) (int, error) {
+	var n int
+	var err error
+	if r.inflate != nil {
+		n, err = r.inflate.Read(p)
+	} else {
+		n, err = r.rawRead(p)
+	}
+	if n > 0 {
+		r.read += int64(n)
+		if r.limit > 0 && r.read > r.limit {
+			r.c.CloseWrite(context.Background(), CloseMessageTooBig, "")
+			r.c.close()
+			return n, fmt.Errorf("websocket: read limit of %d bytes exceeded", r.limit)
+		}
+		if r.compress {
+			r.dictAccum = append(r.dictAccum, p[:n]...)
+		}
+	}
+	if err == io.EOF && r.compress {
+		r.c.commitReadDict(r.dictAccum)
+	}
+	return n, err
+}
 
 // SetDeadline sets the deadline for future Read calls. SetDeadline overrides
-// the timeout set by ReadOptions.MessageTimeout  A zero value for t means Read
-// will not time out.SetDeadline overrides the timeout specified in
-// DialOptions.ReadTimeout and UpgradeOptions.ReadTimeout.
-func (r Reader) SetDeadline(t time.Time) error {}
+// the timeout set by UpgradeOptions.ReadTimeout. A zero value for t means
+// Read will not time out.
+func (r *Reader) SetDeadline(t time.Time) error {
+	return r.c.conn.SetReadDeadline(t)
+}
+
+// SetLimit sets a limit on the number of decompressed bytes read in
+// subsequent calls to Read. This limit overrides the limit specified in the
+// UpgradeOptions.ReadLimit field. When the limit is breached, a close
+// message is sent to the peer and the connection is closed. A value of zero
+// specifies no limit. Each frame's length is still checked against the
+// connection's own limit, so SetLimit does not admit a frame larger than
+// that limit.
+func (r *Reader) SetLimit(n int64) {
+	r.limit = n
+}
+
+// ReadOptions specifies options for ReadBytes, ReadString and ReadJSON.
+type ReadOptions struct {
+	// ReadLimit overrides the connection's read limit for this call, in the
+	// same way as Reader.SetLimit.
+	ReadLimit int64
+}
 
-// SetLimit sets a limit on the number of bytes read in subsequent calls to
-// Read. This limit overrides the limit specified in the RunOptions.ReadLimit
-// field. When the limit is breached, a close message is sent to the
-// peer and the connection is closed. A value of zero specifies no limit.
-func (r Reader) SetLimit(n int64) {}
+// WriteOptions specifies options for WriteBytes, WriteString and WriteJSON.
+type WriteOptions struct {
+	// Binary marks the message as a binary data message.
+	Binary bool
+
+	// Compress overrides the connection's default compression behavior for
+	// this message.
+	Compress *bool
+}
 
 // ReadBytes reads the next message and returns it as a slice of bytes.
-func ReadBytes(c *Conn, options *ReadOptions) (data []byte, isBinary bool, err error) {}
+func ReadBytes(ctx context.Context, c *Conn, options *ReadOptions) (data []byte, isBinary bool, err error) {
+	r, err := c.Reader(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if options != nil && options.ReadLimit != 0 {
+		r.SetLimit(options.ReadLimit)
+	}
+	data, err = io.ReadAll(&r)
+	return data, r.Binary(), err
+}
 
 // ReadString reads the next message and returns it as a string.
-func ReadString(c *Conn, options *ReadOptions) (data string, isBinary bool, err error) {}
+func ReadString(ctx context.Context, c *Conn, options *ReadOptions) (data string, isBinary bool, err error) {
+	b, isBinary, err := ReadBytes(ctx, c, options)
+	return string(b), isBinary, err
+}
 
 // WriteBytes writes a slice of bytes as a WebSocket message.
-func WriteBytes(c *Conn, options *WriteOptions, data []byte) error {}
+func WriteBytes(ctx context.Context, c *Conn, options *WriteOptions, data []byte) error {
+	w, err := c.Writer(ctx)
+	if err != nil {
+		return err
+	}
+	if options != nil {
+		w.SetBinary(options.Binary)
+		if options.Compress != nil {
+			w.SetCompress(*options.Compress)
+		}
+	}
+	w.SetFinal()
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
 
 // WriteString writes a string as a WebSocket message.
-func WriteString(c *Conn, options *WriteOptions, data string) error {}
-
-// WriteJSON encodes v as JSON and writes it as a message.
-func WriteJSON(c *Conn, options *WriteOptions, v interface{}) error {}
-
-// ReadJSON decodes the next received message as JSON to the value pointed to
-// by v.
-func ReadJSON(c *Conn, opitions *ReadOoptions, v interface{}) error {}
+func WriteString(ctx context.Context, c *Conn, options *WriteOptions, data string) error {
+	return WriteBytes(ctx, c, options, []byte(data))
+}